@@ -0,0 +1,127 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth 管理接口鉴权
+func (h *Handler) AdminAuth(c *gin.Context) {
+	if h.Config().Admin.Token == "" {
+		BuildResultError(c, http.StatusForbidden, CodeForbidden, "admin api disabled")
+		c.Abort()
+		return
+	}
+
+	reqToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !h.isAdminToken(reqToken) {
+		BuildResultError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid token")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// isAdminToken 检查管理 Token，未配置 Token 时总是返回 false
+func (h *Handler) isAdminToken(token string) bool {
+	adminToken := h.Config().Admin.Token
+	return adminToken != "" && token == adminToken
+}
+
+// ResultFilesStats 合成结果文件和TTS缓存的统计
+func (h *Handler) ResultFilesStats(c *gin.Context) {
+	BuildResultOk(c, gin.H{
+		"result_files": h.TTS.Janitor().Stats(),
+		"cache_bytes":  h.TTS.Cache().Size(),
+	})
+}
+
+// TTSStats 每个会话的TTS队列状态和合成后端的熔断状态
+func (h *Handler) TTSStats(c *gin.Context) {
+	queues := make([]gin.H, 0)
+	for _, s := range h.sessions.all() {
+		if s.roomData == nil {
+			continue
+		}
+		queues = append(queues, gin.H{
+			"room_id": s.roomData.RoomID,
+			"queue":   s.ttsQueue.Stats(),
+		})
+	}
+	BuildResultOk(c, gin.H{
+		"queues":   queues,
+		"backends": h.TTS.Statuses(),
+	})
+}
+
+type SkipTTSRequest struct {
+	RoomID int `json:"room_id" binding:"required"`
+}
+
+// SkipTTS 跳过房间正在播放的语音
+func (h *Handler) SkipTTS(c *gin.Context) {
+	var req SkipTTSRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	skipped := false
+	for _, s := range h.sessions.all() {
+		if s.roomData != nil && s.roomData.RoomID == req.RoomID && s.skipTTS() {
+			skipped = true
+		}
+	}
+	if !skipped {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, "no tts playing")
+		return
+	}
+	BuildResultOk(c, nil)
+}
+
+func (h *Handler) ListRoles(c *gin.Context) {
+	roles, err := h.Dao.ListRoles(c.Request.Context())
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, roles)
+}
+
+type SetRoleRequest struct {
+	OpenID string `json:"open_id" binding:"required"`
+	Uname  string `json:"uname"`
+	Role   string `json:"role" binding:"required"`
+}
+
+func (h *Handler) SetRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if !dao.IsValidRole(req.Role) {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, "invalid role")
+		return
+	}
+
+	userRole := &dao.UserRole{
+		OpenID: req.OpenID,
+		Uname:  req.Uname,
+		Role:   req.Role,
+	}
+	if err := h.Dao.SetRole(c.Request.Context(), userRole); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, userRole)
+}
+
+func (h *Handler) DeleteRole(c *gin.Context) {
+	if err := h.Dao.DeleteRole(c.Request.Context(), c.Param("open_id")); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, nil)
+}
@@ -0,0 +1,334 @@
+// Package bilibilitest 提供一个本地的B站直播开放平台服务，用于在没有网络的情况下进行集成测试
+//
+// 实现了 /v2/app/start、/v2/app/heartbeat、/v2/app/end 接口以及长连，
+// 可以通过 Send 系列方法向所有已鉴权的长连推送 LIVE_OPEN_PLATFORM_* 消息
+package bilibilitest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
+	"github.com/vtb-link/bianka/live"
+	"github.com/vtb-link/bianka/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CodeOK          = 0
+	CodeInvalidCode = 7001 // 身份码错误
+	CodeInvalidGame = 7003 // 场次不存在
+)
+
+type Server struct {
+	*httptest.Server
+
+	AnchorInfo live.AnchorInfo
+
+	games      map[string]string // game_id -> code
+	heartbeats int
+	ends       int
+
+	conns map[*conn]struct{}
+
+	mutex    sync.Mutex
+	connCond *sync.Cond
+}
+
+type conn struct {
+	ws         *websocket.Conn
+	authed     bool
+	writeMutex sync.Mutex
+}
+
+func (c *conn) write(op uint32, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	msg := proto.PackMessage(proto.HeaderDefaultSequence, op, payload)
+	return c.ws.WriteMessage(websocket.BinaryMessage, msg.ToBytes())
+}
+
+func NewServer() *Server {
+	s := &Server{
+		AnchorInfo: live.AnchorInfo{
+			RoomID: 1,
+			Uname:  "test",
+			UFace:  "https://i0.hdslb.com/test.jpg",
+			Uid:    1,
+		},
+		games: make(map[string]string),
+		conns: make(map[*conn]struct{}),
+	}
+	s.connCond = sync.NewCond(&s.mutex)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/app/start", s.handleAppStart)
+	mux.HandleFunc("/v2/app/heartbeat", s.handleAppHeartbeat)
+	mux.HandleFunc("/v2/app/end", s.handleAppEnd)
+	mux.HandleFunc("/sub", s.handleWebSocket)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func writeResp(w http.ResponseWriter, code int64, data interface{}) {
+	raw, _ := json.Marshal(data)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&live.BaseResp{
+		Code:      code,
+		Message:   "ok",
+		RequestID: uuid.NewV4().String(),
+		Data:      raw,
+	})
+}
+
+func (s *Server) handleAppStart(w http.ResponseWriter, r *http.Request) {
+	var req live.AppStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		writeResp(w, CodeInvalidCode, nil)
+		return
+	}
+
+	gameId := uuid.NewV4().String()
+	s.mutex.Lock()
+	s.games[gameId] = req.Code
+	s.mutex.Unlock()
+
+	wsUrl := "ws" + strings.TrimPrefix(s.URL, "http") + "/sub"
+	writeResp(w, CodeOK, &live.AppStartResponse{
+		AnchorInfo: s.AnchorInfo,
+		GameInfo:   live.GameInfo{GameID: gameId},
+		WebsocketInfo: live.WebSocketInfo{
+			AuthBody: fmt.Sprintf(`{"game_id":"%s"}`, gameId),
+			WssLink:  []string{wsUrl},
+		},
+	})
+}
+
+func (s *Server) handleAppHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req live.AppHeartbeatRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	s.mutex.Lock()
+	_, ok := s.games[req.GameID]
+	if ok {
+		s.heartbeats++
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		writeResp(w, CodeInvalidGame, nil)
+		return
+	}
+	writeResp(w, CodeOK, struct{}{})
+}
+
+func (s *Server) handleAppEnd(w http.ResponseWriter, r *http.Request) {
+	var req live.AppEndRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	s.mutex.Lock()
+	_, ok := s.games[req.GameID]
+	if ok {
+		delete(s.games, req.GameID)
+		s.ends++
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		writeResp(w, CodeInvalidGame, nil)
+		return
+	}
+	writeResp(w, CodeOK, struct{}{})
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: ws}
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.connCond.Broadcast()
+		s.mutex.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		_, buf, err := ws.ReadMessage()
+		if err != nil {
+			// 客户端主动关闭时等待客户端断开连接，立即断开会让客户端的读取协程和 Close 同时关闭连接
+			if _, ok := err.(*websocket.CloseError); ok {
+				io.Copy(io.Discard, ws.UnderlyingConn())
+			}
+			return
+		}
+		msgs, err := proto.UnpackMessage(buf)
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			switch msg.Operation() {
+			case proto.OperationUserAuthentication:
+				{
+					var body struct {
+						GameID string `json:"game_id"`
+					}
+					json.Unmarshal(msg.Payload(), &body)
+
+					s.mutex.Lock()
+					_, ok := s.games[body.GameID]
+					s.mutex.Unlock()
+					if !ok {
+						c.write(proto.OperationUserAuthenticationReply, []byte(fmt.Sprintf(`{"code":%d}`, CodeInvalidGame)))
+						return
+					}
+
+					if err := c.write(proto.OperationUserAuthenticationReply, []byte(`{"code":0}`)); err != nil {
+						return
+					}
+					s.mutex.Lock()
+					c.authed = true
+					s.conns[c] = struct{}{}
+					s.connCond.Broadcast()
+					s.mutex.Unlock()
+				}
+			case proto.OperationHeartbeat:
+				{
+					if err := c.write(proto.OperationHeartbeatReply, nil); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// WaitConnected 等待至少 n 个已鉴权的长连
+func (s *Server) WaitConnected(n int, timeout time.Duration) error {
+	return s.waitConns(func(conns int) bool { return conns >= n }, timeout, "wait connected timeout")
+}
+
+// WaitDisconnected 等待客户端关闭所有长连，客户端关闭连接时已经退出读取协程
+func (s *Server) WaitDisconnected(timeout time.Duration) error {
+	return s.waitConns(func(conns int) bool { return conns == 0 }, timeout, "wait disconnected timeout")
+}
+
+func (s *Server) waitConns(done func(conns int) bool, timeout time.Duration, errMsg string) error {
+	timer := time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		s.connCond.Broadcast()
+		s.mutex.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for !done(len(s.conns)) {
+		if time.Now().After(deadline) {
+			return errors.New(errMsg)
+		}
+		s.connCond.Wait()
+	}
+	return nil
+}
+
+// Games 当前未结束的场次数量
+func (s *Server) Games() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.games)
+}
+
+// Ends 已经调用 AppEnd 的场次数量
+func (s *Server) Ends() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ends
+}
+
+// Heartbeats 收到的心跳数量
+func (s *Server) Heartbeats() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.heartbeats
+}
+
+// Send 向所有已鉴权的长连推送消息
+func (s *Server) Send(cmd string, data interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"cmd":  cmd,
+		"data": data,
+	})
+	if err != nil {
+		return err
+	}
+	return s.SendRaw(payload)
+}
+
+// SendRaw 推送原始消息，可以用于推送录制的消息
+func (s *Server) SendRaw(payload []byte) error {
+	s.mutex.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mutex.Unlock()
+
+	if len(conns) == 0 {
+		return errors.New("no connection")
+	}
+	for _, c := range conns {
+		if err := c.write(proto.OperationMessage, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) SendDanmu(d *proto.CmdDanmuData) error {
+	return s.Send(proto.CmdLiveOpenPlatformDanmu, d)
+}
+
+func (s *Server) SendSuperChat(d *proto.CmdSuperChatData) error {
+	return s.Send(proto.CmdLiveOpenPlatformSuperChat, d)
+}
+
+func (s *Server) SendGift(d *proto.CmdSendGiftData) error {
+	return s.Send(proto.CmdLiveOpenPlatformSendGift, d)
+}
+
+func (s *Server) SendGuard(d *proto.CmdGuardData) error {
+	return s.Send(proto.CmdLiveOpenPlatformGuard, d)
+}
+
+func (s *Server) SendRoomEnter(d *proto.CmdLiveRoomEnterData) error {
+	return s.Send(proto.CmdLiveOpenPlatformRoomEnter, d)
+}
+
+func (s *Server) SendLiveStart(d *proto.CmdLiveStartData) error {
+	return s.Send(proto.CmdLiveOpenPlatformLiveStart, d)
+}
+
+func (s *Server) SendLiveEnd(d *proto.CmdLiveEndData) error {
+	return s.Send(proto.CmdLiveOpenPlatformLiveEnd, d)
+}
+
+// Close 关闭所有长连并关闭服务
+func (s *Server) Close() {
+	s.mutex.Lock()
+	for c := range s.conns {
+		c.ws.Close()
+	}
+	s.mutex.Unlock()
+	s.Server.Close()
+}
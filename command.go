@@ -0,0 +1,130 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	CommandPrefix = "/" // 弹幕指令前缀，只有主播和房管可以使用
+
+	CommandAI   = "ai"   // /ai 内容：强制触发大模型响应
+	CommandLLM  = "llm"  // /llm on|off：开关大模型
+	CommandRole = "role" // /role 角色|none 用户名：设置最近发言用户的角色
+	CommandSkip = "skip" // /skip：跳过正在播放的语音
+
+	RoleNone = "none"
+)
+
+func canRunCommand(role string) bool {
+	return role == dao.RoleOwner || role == dao.RoleModerator
+}
+
+// handleCommand 处理主播和房管发送的弹幕指令
+func (s *Session) handleCommand(u UserData, role string, msg string) {
+	args := strings.Fields(strings.TrimPrefix(msg, CommandPrefix))
+	if len(args) == 0 {
+		return
+	}
+
+	log.Infof("command from %s(%s): %s", u.Uname, role, msg)
+	if err := s.runCommand(u, role, args[0], args[1:]); err != nil {
+		s.BroadcastError(ResultTypeCommand, CodeBadRequest, err.Error())
+		return
+	}
+	s.Broadcast(ResultTypeCommand, gin.H{
+		"uname":   u.Uname,
+		"command": msg,
+	})
+}
+
+func (s *Session) runCommand(u UserData, role string, command string, args []string) error {
+	switch command {
+	case CommandAI:
+		{
+			if len(args) == 0 {
+				return fmt.Errorf("usage: /%s 内容", CommandAI)
+			}
+			s.historyMsgLru.Add(fmt.Sprintf("command-%d", time.Now().UnixNano()), &ChatMessage{
+				OpenId:    u.OpenID,
+				User:      u.Uname,
+				Message:   strings.Join(args, " "),
+				Timestamp: time.Now(),
+			})
+			s.startLlmReply(true)
+			return nil
+		}
+	case CommandLLM:
+		{
+			if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+				return fmt.Errorf("usage: /%s on|off", CommandLLM)
+			}
+			cfg := s.liveConfig()
+			cfg.DisableLlm = args[0] == "off"
+			s.SetConfig(cfg)
+			return nil
+		}
+	case CommandRole:
+		{
+			if len(args) != 2 {
+				return fmt.Errorf("usage: /%s 角色|%s 用户名", CommandRole, RoleNone)
+			}
+			return s.setRoleByUname(role, args[0], args[1])
+		}
+	case CommandSkip:
+		{
+			if !s.skipTTS() {
+				return fmt.Errorf("no tts playing")
+			}
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+// setRoleByUname 根据用户名在最近的弹幕中查找用户并设置角色
+// 房管只能设置 VIP 和屏蔽，且不能修改主播和其他房管
+func (s *Session) setRoleByUname(operatorRole string, role string, uname string) error {
+	if role != RoleNone && !dao.IsValidRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	var target *ChatMessage
+	for _, msg := range s.historyMsgLru.Values() {
+		if msg.User == uname && (target == nil || msg.Timestamp.After(target.Timestamp)) {
+			target = msg
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("user %s not found in recent danmu", uname)
+	}
+
+	ctx := context.Background()
+	if operatorRole != dao.RoleOwner {
+		if role == dao.RoleOwner || role == dao.RoleModerator {
+			return fmt.Errorf("permission denied")
+		}
+		targetRole, err := s.h.Dao.GetRole(ctx, target.OpenId)
+		if err != nil {
+			return err
+		}
+		if canRunCommand(targetRole) {
+			return fmt.Errorf("permission denied")
+		}
+	}
+
+	if role == RoleNone {
+		return s.h.Dao.DeleteRole(ctx, target.OpenId)
+	}
+	return s.h.Dao.SetRole(ctx, &dao.UserRole{
+		OpenID: target.OpenId,
+		Uname:  target.User,
+		Role:   role,
+	})
+}
@@ -1,20 +1,275 @@
 package config
 
 import (
+	"fmt"
 	"github.com/pelletier/go-toml/v2"
 	"os"
 )
 
 func ParseConfig(filePath string) (*Config, error) {
-	var cfg Config
+	cfg := defaultConfig()
 
 	file, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+	// 先用默认值填充主播配置和音色再解析，配置文件中显式设置的 0 不会被默认值覆盖
+	var names struct {
+		Profiles map[string]interface{} `toml:"profiles"`
+		Voices   map[string]interface{} `toml:"voices"`
+	}
+	if err := toml.Unmarshal(file, &names); err != nil {
+		return nil, err
+	}
+	cfg.Profiles = make(map[string]*ProfileConfig, len(names.Profiles))
+	for name := range names.Profiles {
+		p := DefaultProfileConfig
+		cfg.Profiles[name] = &p
+	}
+	cfg.Voices = make(map[string]*VoiceConfig, len(names.Voices))
+	for name := range names.Voices {
+		cfg.Voices[name] = newVoiceConfig()
+	}
 	if err := toml.Unmarshal(file, &cfg); err != nil {
 		return nil, err
 	}
+	setDefaults(&cfg)
+	if err := validate(&cfg); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
+
+// defaultConfig 有默认值的配置段先用默认值填充，配置段中只写了部分字段时其他字段使用默认值，显式设置的 0 不会被覆盖
+func defaultConfig() Config {
+	tts := DefaultTTSConfig
+	tts.Backends = append([]string(nil), DefaultTTSConfig.Backends...)
+	queue := DefaultTTSQueueConfig
+	cache := DefaultTTSCacheConfig
+	normalize := DefaultTTSNormalizeConfig
+	retry := DefaultTTSRetryConfig
+	resultFiles := DefaultResultFilesConfig
+	leaderboard := DefaultLeaderboardConfig
+	leaderboard.Windows = append([]string(nil), DefaultLeaderboardConfig.Windows...)
+	return Config{
+		TTS:          &tts,
+		TTSQueue:     &queue,
+		TTSCache:     &cache,
+		TTSNormalize: &normalize,
+		TTSRetry:     &retry,
+		ResultFiles:  &resultFiles,
+		Leaderboard:  &leaderboard,
+	}
+}
+
+// newVoiceConfig 配置文件中的音色，未配置的 voice、format、sample_rate、volume 使用默认值
+func newVoiceConfig() *VoiceConfig {
+	return &VoiceConfig{
+		Voice:      DefaultVoiceConfig.Voice,
+		Format:     DefaultVoiceConfig.Format,
+		SampleRate: DefaultVoiceConfig.SampleRate,
+		Volume:     DefaultVoiceConfig.Volume,
+	}
+}
+
+func setDefaults(cfg *Config) {
+	if cfg.Admin == nil {
+		cfg.Admin = &AdminConfig{}
+	}
+	if len(cfg.TTS.Backends) == 0 {
+		cfg.TTS.Backends = DefaultTTSConfig.Backends
+	}
+	if cfg.TTS.LongText == "" {
+		cfg.TTS.LongText = DefaultTTSConfig.LongText
+	}
+	if cfg.TTS.LongTextThreshold == 0 {
+		cfg.TTS.LongTextThreshold = DefaultTTSConfig.LongTextThreshold
+	}
+	for _, b := range cfg.TTS.HTTPBackends {
+		if b.Timeout == 0 {
+			b.Timeout = DefaultHTTPTTSTimeout
+		}
+	}
+	if cfg.Voices == nil {
+		cfg.Voices = map[string]*VoiceConfig{}
+	}
+	if _, ok := cfg.Voices[DefaultVoiceName]; !ok {
+		v := DefaultVoiceConfig
+		cfg.Voices[DefaultVoiceName] = &v
+	}
+	if cfg.TTSQueue.Workers == 0 {
+		cfg.TTSQueue.Workers = DefaultTTSQueueConfig.Workers
+	}
+	if cfg.TTSRetry.RetryBackoff == 0 {
+		cfg.TTSRetry.RetryBackoff = DefaultTTSRetryConfig.RetryBackoff
+	}
+	if cfg.TTSRetry.ProbeInterval == 0 {
+		cfg.TTSRetry.ProbeInterval = DefaultTTSRetryConfig.ProbeInterval
+	}
+	if cfg.ResultFiles.Retention == 0 {
+		cfg.ResultFiles.Retention = DefaultResultFilesConfig.Retention
+	}
+	if cfg.ResultFiles.CleanInterval == 0 {
+		cfg.ResultFiles.CleanInterval = DefaultResultFilesConfig.CleanInterval
+	}
+	if cfg.Leaderboard.Limit == 0 {
+		cfg.Leaderboard.Limit = DefaultLeaderboardConfig.Limit
+	}
+	if cfg.Leaderboard.Windows == nil {
+		cfg.Leaderboard.Windows = DefaultLeaderboardConfig.Windows
+	}
+	if cfg.Templates == nil {
+		cfg.Templates = &TemplatesConfig{}
+	}
+	t := cfg.Templates
+	if len(t.Danmu) == 0 {
+		t.Danmu = DefaultTemplatesConfig.Danmu
+	}
+	if len(t.SuperChat) == 0 {
+		t.SuperChat = DefaultTemplatesConfig.SuperChat
+	}
+	if len(t.Gift) == 0 {
+		t.Gift = DefaultTemplatesConfig.Gift
+	}
+	if len(t.Guard) == 0 {
+		t.Guard = DefaultTemplatesConfig.Guard
+	}
+	if len(t.LiveStart) == 0 {
+		t.LiveStart = DefaultTemplatesConfig.LiveStart
+	}
+	if len(t.LiveEnd) == 0 {
+		t.LiveEnd = DefaultTemplatesConfig.LiveEnd
+	}
+	if len(t.RoomEnter) == 0 {
+		t.RoomEnter = DefaultTemplatesConfig.RoomEnter
+	}
+
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]*ProfileConfig)
+	}
+	if _, ok := cfg.Profiles[DefaultProfileName]; !ok {
+		p := DefaultProfileConfig
+		cfg.Profiles[DefaultProfileName] = &p
+	}
+}
+
+func validate(cfg *Config) error {
+	for _, name := range cfg.TTS.Backends {
+		if name == TTSBackendAliyun {
+			continue
+		}
+		b, ok := cfg.TTS.HTTPBackends[name]
+		if !ok {
+			return fmt.Errorf("tts backend %s not found", name)
+		}
+		if b.URL == "" {
+			return fmt.Errorf("tts backend %s url is empty", name)
+		}
+	}
+	switch cfg.TTS.LongText {
+	case LongTextSplit, LongTextAliyunLong, LongTextNone:
+	default:
+		return fmt.Errorf("tts long_text %s must be %s, %s or %s", cfg.TTS.LongText, LongTextSplit, LongTextAliyunLong, LongTextNone)
+	}
+	if cfg.TTS.LongTextThreshold < 0 {
+		return fmt.Errorf("tts long_text_threshold must not be negative")
+	}
+	for event, name := range cfg.TTS.EventVoices {
+		if !isVoiceEvent(event) {
+			return fmt.Errorf("tts event_voices: unknown event %s", event)
+		}
+		if _, ok := cfg.Voices[name]; !ok {
+			return fmt.Errorf("tts event_voices: voice %s not found", name)
+		}
+	}
+	for name, v := range cfg.Voices {
+		if v.Format != "wav" && v.Format != "mp3" && v.Format != "pcm" {
+			return fmt.Errorf("voice %s: unknown format %s", name, v.Format)
+		}
+		if v.Volume < 0 || v.Volume > 100 {
+			return fmt.Errorf("voice %s: volume must be between 0 and 100", name)
+		}
+		if v.SpeechRate < -500 || v.SpeechRate > 500 || v.PitchRate < -500 || v.PitchRate > 500 {
+			return fmt.Errorf("voice %s: speech_rate and pitch_rate must be between -500 and 500", name)
+		}
+	}
+	if cfg.TTSQueue.MaxBacklog < 0 || cfg.TTSQueue.MaxAge < 0 || cfg.TTSQueue.Workers < 0 || cfg.TTSQueue.AckTimeout < 0 {
+		return fmt.Errorf("tts_queue max_backlog, max_age, workers and ack_timeout must not be negative")
+	}
+	if cfg.TTSCache.MaxSizeMB < 0 {
+		return fmt.Errorf("tts_cache max_size_mb must not be negative")
+	}
+	if cfg.TTSNormalize.CollapseRepeat < 0 || cfg.TTSNormalize.MaxNameLength < 0 {
+		return fmt.Errorf("tts_normalize collapse_repeat and max_name_length must not be negative")
+	}
+	for _, mode := range []string{cfg.TTSNormalize.URL, cfg.TTSNormalize.Emote} {
+		if mode != "" && mode != NormalizeModeStrip && mode != NormalizeModeSpeak {
+			return fmt.Errorf("tts_normalize mode %s must be %s or %s", mode, NormalizeModeStrip, NormalizeModeSpeak)
+		}
+	}
+	if cfg.TTSRetry.MaxRetries < 0 || cfg.TTSRetry.RetryBackoff < 0 || cfg.TTSRetry.BreakerFailures < 0 || cfg.TTSRetry.ProbeInterval < 0 {
+		return fmt.Errorf("tts_retry max_retries, retry_backoff, breaker_failures and probe_interval must not be negative")
+	}
+	if cfg.ResultFiles.Retention < 0 || cfg.ResultFiles.MaxSizeMB < 0 || cfg.ResultFiles.CleanInterval < 0 {
+		return fmt.Errorf("result_files retention, max_size_mb and clean_interval must not be negative")
+	}
+	if cfg.Leaderboard.Limit < 0 || cfg.Leaderboard.PushInterval < 0 {
+		return fmt.Errorf("leaderboard limit and push_interval must not be negative")
+	}
+	for _, window := range cfg.Leaderboard.Windows {
+		if _, err := ParseLeaderboardWindow(window); err != nil {
+			return fmt.Errorf("leaderboard: %w", err)
+		}
+	}
+
+	roomProfiles := make(map[int]string)
+	for name, p := range cfg.Profiles {
+		for _, id := range p.RoomIDs {
+			if other, ok := roomProfiles[id]; ok {
+				return fmt.Errorf("room_id %d is used by profiles %s and %s", id, other, name)
+			}
+			roomProfiles[id] = name
+		}
+		if err := validateProfile(p); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func isVoiceEvent(event string) bool {
+	for _, e := range voiceEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validateProfile(p *ProfileConfig) error {
+	if p.LlmReplyFansMedalLevel < 0 || p.RoomEnterTTSFansMedalLevel < 0 {
+		return fmt.Errorf("fans medal level must not be negative")
+	}
+	if p.GiftComboDuration < 0 || p.DisableLlmByUserCountDuration < 0 ||
+		p.LlmReplyLimitDuration < 0 || p.ProbabilityLlmTriggerDuration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+	if p.DisableLlmByUserCount < 0 || p.LlmReplyLimitCount < 0 {
+		return fmt.Errorf("count must not be negative")
+	}
+	if p.ProbabilityLlmTriggerLevel1Count < 0 ||
+		p.ProbabilityLlmTriggerLevel2Count < p.ProbabilityLlmTriggerLevel1Count {
+		return fmt.Errorf("probability_llm_trigger_level2_count must not be less than level1_count")
+	}
+	for _, probability := range []float64{
+		p.ProbabilityLlmTriggerLevel1,
+		p.ProbabilityLlmTriggerLevel2,
+		p.ProbabilityLlmTriggerLevel3,
+	} {
+		if probability < 0 || probability > 1 {
+			return fmt.Errorf("probability %.2f must be in [0, 1]", probability)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseConfigProfile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(filePath, []byte(`
+[profiles.other]
+room_ids = [123]
+fans_medal_name = "other"
+gift_combo_duration = "2s"
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	cfg, err := ParseConfig(filePath)
+	if err != nil {
+		t.Errorf("ParseConfig err: %v", err)
+		return
+	}
+
+	p := cfg.Profile(123)
+	assert.Equal(t, "other", p.FansMedalName)
+	assert.Equal(t, 2*time.Second, p.GiftComboDuration.Duration())
+	assert.Equal(t, DefaultProfileConfig.LlmReplyLimitCount, p.LlmReplyLimitCount)
+
+	p = cfg.Profile(456)
+	assert.Equal(t, DefaultProfileConfig.FansMedalName, p.FansMedalName)
+}
+
+func TestParseConfigProfileZero(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(filePath, []byte(`
+[profiles.default]
+llm_reply_fans_medal_level = 0
+probability_llm_trigger_level2 = 0
+probability_llm_trigger_level3 = 0
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	cfg, err := ParseConfig(filePath)
+	if err != nil {
+		t.Errorf("ParseConfig err: %v", err)
+		return
+	}
+
+	assert.Equal(t, DefaultVoiceConfig, *cfg.Voice(VoiceEventDanmu))
+
+	// 显式设置的 0 不使用默认值，没有设置的使用默认值
+	p := cfg.Profile(0)
+	assert.Equal(t, 0, p.LlmReplyFansMedalLevel)
+	assert.Equal(t, 0.0, p.ProbabilityLlmTriggerLevel2)
+	assert.Equal(t, 0.0, p.ProbabilityLlmTriggerLevel3)
+	assert.Equal(t, DefaultProfileConfig.RoomEnterTTSFansMedalLevel, p.RoomEnterTTSFansMedalLevel)
+}
+
+func TestParseConfigInvalidProfile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(filePath, []byte(`
+[profiles.default]
+probability_llm_trigger_level3 = 1.5
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	_, err = ParseConfig(filePath)
+	assert.Error(t, err)
+}
+
+func TestParseConfigVoice(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(filePath, []byte(`
+[tts.event_voices]
+llm = "assistant"
+enter = "assistant"
+
+[voices.assistant]
+voice = "other"
+pitch_rate = -100
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	cfg, err := ParseConfig(filePath)
+	if err != nil {
+		t.Errorf("ParseConfig err: %v", err)
+		return
+	}
+
+	v := cfg.Voice(VoiceEventLLM)
+	assert.Equal(t, "other", v.Voice)
+	assert.Equal(t, -100, v.PitchRate)
+	assert.Equal(t, DefaultVoiceConfig.Format, v.Format)
+	assert.Equal(t, DefaultVoiceConfig.Volume, v.Volume)
+	// 欢迎进入直播间和感谢礼物分别配置音色
+	assert.Equal(t, "other", cfg.Voice(VoiceEventEnter).Voice)
+	assert.Equal(t, DefaultVoiceConfig.Voice, cfg.Voice(VoiceEventGift).Voice)
+
+	// 显式设置的 0 不使用默认值
+	err = os.WriteFile(filePath, []byte(`
+[voices.default]
+volume = 0
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+	cfg, err = ParseConfig(filePath)
+	if err != nil {
+		t.Errorf("ParseConfig err: %v", err)
+		return
+	}
+	v = cfg.Voice(VoiceEventLLM)
+	assert.Equal(t, 0, v.Volume)
+	assert.Equal(t, DefaultVoiceConfig.SampleRate, v.SampleRate)
+
+	err = os.WriteFile(filePath, []byte(`
+[tts.event_voices]
+llm = "not_found"
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+	_, err = ParseConfig(filePath)
+	assert.Error(t, err)
+}
+
+func TestParseConfigPartialSection(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(filePath, []byte(`
+[tts_queue]
+workers = 4
+
+[tts_retry]
+max_retries = 0
+
+[result_files]
+max_size_mb = 0
+
+[leaderboard]
+windows = ["all"]
+`), 0644)
+	if err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	cfg, err := ParseConfig(filePath)
+	if err != nil {
+		t.Errorf("ParseConfig err: %v", err)
+		return
+	}
+
+	// 只写了部分字段的配置段，其他字段使用默认值
+	assert.Equal(t, 4, cfg.TTSQueue.Workers)
+	assert.Equal(t, DefaultTTSQueueConfig.MaxBacklog, cfg.TTSQueue.MaxBacklog)
+	assert.Equal(t, DefaultTTSQueueConfig.MaxAge, cfg.TTSQueue.MaxAge)
+	assert.Equal(t, DefaultTTSQueueConfig.AckTimeout, cfg.TTSQueue.AckTimeout)
+
+	// 显式设置的 0 不会被默认值覆盖
+	assert.Equal(t, 0, cfg.TTSRetry.MaxRetries)
+	assert.Equal(t, DefaultTTSRetryConfig.BreakerFailures, cfg.TTSRetry.BreakerFailures)
+	assert.EqualValues(t, 0, cfg.ResultFiles.MaxSizeMB)
+	assert.Equal(t, DefaultResultFilesConfig.Retention, cfg.ResultFiles.Retention)
+
+	assert.Equal(t, []string{"all"}, cfg.Leaderboard.Windows)
+	assert.Equal(t, DefaultLeaderboardConfig.Limit, cfg.Leaderboard.Limit)
+	assert.Equal(t, []string{LeaderboardWindowSession, "168h", LeaderboardWindowAll}, DefaultLeaderboardConfig.Windows)
+
+	// 没有写的配置段使用默认值
+	assert.Equal(t, DefaultTTSNormalizeConfig, *cfg.TTSNormalize)
+	assert.Equal(t, DefaultTTSConfig.Backends, cfg.TTS.Backends)
+}
@@ -1,14 +1,58 @@
 package config
 
+import (
+	"fmt"
+	"time"
+)
+
 const (
 	ResultFilePath = "./result/"
+	TTSCachePath   = "./result/cache/"
+
+	DefaultProfileName = "default"
+	DefaultVoiceName   = "default"
 )
 
 type Config struct {
-	DbPath    string           `toml:"db_path"`
-	QianFan   *QianFanConfig   `toml:"qianfan"`
-	AliyunTTS *AliyunTTSConfig `toml:"aliyun_tts"`
-	BiliBili  *BiliBiliConfig  `toml:"biliBili"`
+	DbPath       string              `toml:"db_path"`
+	RecordPath   string              `toml:"record_path"` // 录制开放平台原始消息的目录，为空时不录制
+	QianFan      *QianFanConfig      `toml:"qianfan"`
+	AliyunTTS    *AliyunTTSConfig    `toml:"aliyun_tts"`
+	TTS          *TTSConfig          `toml:"tts"`
+	TTSQueue     *TTSQueueConfig     `toml:"tts_queue"`
+	TTSCache     *TTSCacheConfig     `toml:"tts_cache"`
+	TTSNormalize *TTSNormalizeConfig `toml:"tts_normalize"`
+	TTSRetry     *TTSRetryConfig     `toml:"tts_retry"`
+	ResultFiles  *ResultFilesConfig  `toml:"result_files"`
+	BiliBili     *BiliBiliConfig     `toml:"biliBili"`
+	Templates    *TemplatesConfig    `toml:"templates"`
+	Admin        *AdminConfig        `toml:"admin"`
+	Leaderboard  *LeaderboardConfig  `toml:"leaderboard"`
+
+	Profiles map[string]*ProfileConfig `toml:"profiles"`
+	Voices   map[string]*VoiceConfig   `toml:"voices"`
+}
+
+// Profile 根据房间号选择主播配置，没有匹配的房间时使用 default
+func (cfg *Config) Profile(roomID int) *ProfileConfig {
+	for _, p := range cfg.Profiles {
+		for _, id := range p.RoomIDs {
+			if id == roomID {
+				return p
+			}
+		}
+	}
+	return cfg.Profiles[DefaultProfileName]
+}
+
+// Voice 根据事件选择音色，没有配置的事件使用 default
+func (cfg *Config) Voice(event string) *VoiceConfig {
+	if name, ok := cfg.TTS.EventVoices[event]; ok {
+		if v, ok := cfg.Voices[name]; ok {
+			return v
+		}
+	}
+	return cfg.Voices[DefaultVoiceName]
 }
 
 type QianFanConfig struct {
@@ -23,9 +67,286 @@ type AliyunTTSConfig struct {
 	AppKey    string `toml:"app_key"`
 }
 
+const TTSBackendAliyun = "aliyun"
+
+// TTSConfig TTS合成后端配置
+type TTSConfig struct {
+	Backends     []string                  `toml:"backends"`      // 按顺序使用的合成后端，失败时使用下一个，aliyun 或者 http_backends 中的名称
+	HTTPBackends map[string]*HTTPTTSConfig `toml:"http_backends"` // 自建的HTTP合成服务
+	EventVoices  map[string]string         `toml:"event_voices"`  // 事件使用的音色，key 为 llm、danmu、gift、enter、system，value 为 voices 中的名称
+
+	LongText          string `toml:"long_text"`           // 超过 long_text_threshold 字的文本：split 按句子分段合成后拼接，aliyun_long 使用阿里云长文本语音合成，none 不处理
+	LongTextThreshold int    `toml:"long_text_threshold"` // 长文本字数
+}
+
+const (
+	LongTextSplit      = "split"
+	LongTextAliyunLong = "aliyun_long"
+	LongTextNone       = "none"
+)
+
+const (
+	VoiceEventLLM    = "llm"    // 大模型回复
+	VoiceEventDanmu  = "danmu"  // 读弹幕和醒目留言
+	VoiceEventGift   = "gift"   // 感谢礼物
+	VoiceEventEnter  = "enter"  // 欢迎进入直播间
+	VoiceEventSystem = "system" // 大航海、开播、下播和下播总结
+)
+
+var voiceEvents = []string{VoiceEventLLM, VoiceEventDanmu, VoiceEventGift, VoiceEventEnter, VoiceEventSystem}
+
+// VoiceConfig 音色配置，未配置（零值）的 voice、format、sample_rate 和 volume 使用 DefaultVoiceConfig
+type VoiceConfig struct {
+	Voice      string `toml:"voice"`       // 发音人
+	Format     string `toml:"format"`      // 音频格式，wav、mp3 或 pcm
+	SampleRate int    `toml:"sample_rate"` // 采样率
+	Volume     int    `toml:"volume"`      // 音量，0~100
+	SpeechRate int    `toml:"speech_rate"` // 语速，-500~500
+	PitchRate  int    `toml:"pitch_rate"`  // 语调，-500~500
+}
+
+var DefaultVoiceConfig = VoiceConfig{
+	Voice:      "voice-3e06127",
+	Format:     "wav",
+	SampleRate: 48000,
+	Volume:     50,
+	SpeechRate: -100,
+}
+
+// HTTPTTSConfig HTTP合成服务，POST JSON 格式的合成参数，返回音频文件
+type HTTPTTSConfig struct {
+	URL     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+	Timeout Duration          `toml:"timeout"`
+}
+
+var DefaultTTSConfig = TTSConfig{
+	Backends:          []string{TTSBackendAliyun},
+	LongText:          LongTextSplit,
+	LongTextThreshold: 300,
+}
+
+var DefaultHTTPTTSTimeout = Duration(30 * time.Second)
+
+// TTSQueueConfig TTS队列配置
+type TTSQueueConfig struct {
+	MaxBacklog int      `toml:"max_backlog"` // 等待播放的任务上限，包括已经合成好的，超过时丢弃最早的低优先级任务，为0时不限制
+	MaxAge     Duration `toml:"max_age"`     // 礼物以下优先级任务从收到到开始播放的最长等待时间，为0时不限制
+	Workers    int      `toml:"workers"`     // 同时合成的任务数量，结果仍然按顺序返回
+	AckTimeout Duration `toml:"ack_timeout"` // 音频时长之外等待前端确认播放完成的时间，超时后返回下一条，为0时不等待
+}
+
+var DefaultTTSQueueConfig = TTSQueueConfig{
+	MaxBacklog: 20,
+	MaxAge:     Duration(30 * time.Second),
+	Workers:    2,
+	AckTimeout: Duration(5 * time.Second),
+}
+
+// TTSCacheConfig TTS合成结果缓存配置
+type TTSCacheConfig struct {
+	MaxSizeMB int64 `toml:"max_size_mb"` // 缓存大小上限，为0时不缓存
+}
+
+func (cfg *TTSCacheConfig) MaxSizeBytes() int64 {
+	return cfg.MaxSizeMB * 1024 * 1024
+}
+
+var DefaultTTSCacheConfig = TTSCacheConfig{
+	MaxSizeMB: 512,
+}
+
+const (
+	NormalizeModeStrip = "strip" // 删除
+	NormalizeModeSpeak = "speak" // 转换成可以读出的文字
+)
+
+// TTSNormalizeConfig 合成前的文本整理，每条规则可以单独关闭
+type TTSNormalizeConfig struct {
+	CollapseRepeat   int    `toml:"collapse_repeat"`    // 连续重复的字符最多保留几个，为0时不处理
+	VerbalizeNumbers bool   `toml:"verbalize_numbers"`  // 数字转换成中文读法
+	URL              string `toml:"url"`                // 网址，strip 删除，speak 只读域名，为空时不处理
+	Emote            string `toml:"emote"`              // [dog] 这样的表情，strip 删除，speak 读出括号内的文字，为空时不处理
+	MaxNameLength    int    `toml:"max_name_length"`    // 用户名最多保留几个字，为0时不处理
+	StripNameSymbols bool   `toml:"strip_name_symbols"` // 删除用户名中的数字和符号
+}
+
+var DefaultTTSNormalizeConfig = TTSNormalizeConfig{
+	CollapseRepeat:   3,
+	VerbalizeNumbers: true,
+	URL:              NormalizeModeSpeak,
+	Emote:            NormalizeModeStrip,
+	MaxNameLength:    12,
+	StripNameSymbols: true,
+}
+
+// TTSRetryConfig 合成失败重试和熔断配置
+type TTSRetryConfig struct {
+	MaxRetries      int      `toml:"max_retries"`      // 网络错误和超时的重试次数，为0时不重试
+	RetryBackoff    Duration `toml:"retry_backoff"`    // 第一次重试前的等待时间，之后每次翻倍，带随机抖动
+	BreakerFailures int      `toml:"breaker_failures"` // 连续失败多少次后熔断，鉴权和额度错误立即熔断，为0时不熔断
+	ProbeInterval   Duration `toml:"probe_interval"`   // 熔断后探测恢复的间隔
+}
+
+var DefaultTTSRetryConfig = TTSRetryConfig{
+	MaxRetries:      2,
+	RetryBackoff:    Duration(500 * time.Millisecond),
+	BreakerFailures: 5,
+	ProbeInterval:   Duration(30 * time.Second),
+}
+
+// ResultFilesConfig 合成结果文件清理配置，不包括TTS缓存
+type ResultFilesConfig struct {
+	Retention     Duration `toml:"retention"`      // 保留时间
+	MaxSizeMB     int64    `toml:"max_size_mb"`    // 总大小上限，超过时删除最早的文件，为0时不限制
+	CleanInterval Duration `toml:"clean_interval"` // 清理间隔
+}
+
+func (cfg *ResultFilesConfig) MaxSizeBytes() int64 {
+	return cfg.MaxSizeMB * 1024 * 1024
+}
+
+var DefaultResultFilesConfig = ResultFilesConfig{
+	Retention:     Duration(time.Hour),
+	MaxSizeMB:     1024,
+	CleanInterval: Duration(5 * time.Minute),
+}
+
 type BiliBiliConfig struct {
 	AccessKey           string `toml:"access_key"`
 	SecretKey           string `toml:"secret_key"`
 	AppId               int64  `toml:"app_id"`
 	DisableValidateSign bool   `toml:"disable_validate_sign"`
+	OpenPlatformHost    string `toml:"open_platform_host"` // 开放平台地址，为空时使用线上环境，测试时可以指向 bilibilitest
+}
+
+// AdminConfig 管理接口配置，Token 为空时管理接口不可用
+type AdminConfig struct {
+	Token string `toml:"token"`
+}
+
+const (
+	LeaderboardWindowSession = "session" // 最近一场直播
+	LeaderboardWindowAll     = "all"     // 所有时间
+)
+
+// LeaderboardConfig 排行榜配置
+type LeaderboardConfig struct {
+	Limit        int      `toml:"limit"`         // 每个排行榜的人数
+	Windows      []string `toml:"windows"`       // 统计范围，session、all 或者时间范围如 "168h"
+	PushInterval Duration `toml:"push_interval"` // 推送排行榜的间隔，为0时只在下播时推送
+}
+
+var DefaultLeaderboardConfig = LeaderboardConfig{
+	Limit:        10,
+	Windows:      []string{LeaderboardWindowSession, "168h", LeaderboardWindowAll},
+	PushInterval: Duration(time.Minute),
+}
+
+// ParseLeaderboardWindow 解析排行榜统计范围，session 和 all 返回0
+func ParseLeaderboardWindow(window string) (time.Duration, error) {
+	if window == LeaderboardWindowSession || window == LeaderboardWindowAll {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid leaderboard window: %s", window)
+	}
+	return d, nil
+}
+
+// TemplatesConfig TTS文本模板，使用 text/template 语法，每种事件可以配置多个模板随机选择
+type TemplatesConfig struct {
+	SSML bool `toml:"ssml"` // 模板使用 SSML，可以添加 <break time="500ms"/> 等标签，变量会转义，渲染结果自动添加 <speak>
+
+	Danmu     []string `toml:"danmu"`      // 弹幕，数据为 DanmuData
+	SuperChat []string `toml:"super_chat"` // 醒目留言，数据为 SuperChatData
+	Gift      []string `toml:"gift"`       // 礼物，数据为 GiftData，GiftNum 为连击合并后的数量
+	Guard     []string `toml:"guard"`      // 大航海，数据为 GuardData
+	LiveStart []string `toml:"live_start"` // 开始直播，数据为 RoomData
+	LiveEnd   []string `toml:"live_end"`   // 结束直播，数据为 RoomData
+	RoomEnter []string `toml:"room_enter"` // 进入直播间，数据为 RoomEnterData
+
+	LiveSummary []string `toml:"live_summary"` // 可选，下播总结，数据为 dao.LiveSession
+}
+
+var DefaultTemplatesConfig = TemplatesConfig{
+	Danmu:     []string{"{{.Uname}}说：{{.Msg}}"},
+	SuperChat: []string{"谢谢{{.Uname}}酱的醒目留言：{{.Msg}}"},
+	Gift:      []string{"谢谢{{.Uname}}酱赠送的{{.GiftNum}}个{{.GiftName}} 么么哒"},
+	Guard:     []string{"谢谢{{.Uname}}酱赠送的{{.GuardNum}}个{{.GuardUnit}}{{guardName .GuardLevel}}，么么哒"},
+	LiveStart: []string{"主人开始直播啦，弹幕姬启动！"},
+	LiveEnd:   []string{"主人直播结束啦，今天辛苦了！"},
+	RoomEnter: []string{"欢迎{{if gt .GuardLevel 0}}{{guardName .GuardLevel}}{{end}}{{.Uname}}酱来到直播间"},
+}
+
+// ProfileConfig 主播配置，未配置（零值）的字段使用 DefaultProfileConfig
+type ProfileConfig struct {
+	RoomIDs []int `toml:"room_ids"` // 使用该配置的房间号
+
+	FansMedalName string `toml:"fans_medal_name"` // 粉丝牌名称
+
+	LlmReplyFansMedalLevel     int      `toml:"llm_reply_fans_medal_level"`      // 可以触发大模型响应的最小粉丝牌等级
+	RoomEnterTTSFansMedalLevel int      `toml:"room_enter_tts_fans_medal_level"` // 可以触发进入直播间TTS提示的最小粉丝牌等级
+	LlmReplyUnames             []string `toml:"llm_reply_unames"`                // 不需要粉丝牌也可以触发大模型响应的用户名
+
+	GiftComboDuration Duration `toml:"gift_combo_duration"` // 礼物连击时间，连击结束后会合并播放TTS
+
+	DisableLlmByUserCountDuration Duration `toml:"disable_llm_by_user_count_duration"` // 统计间隔时间内用户数量，用于触发暂停大模型
+	DisableLlmByUserCount         int      `toml:"disable_llm_by_user_count"`          // 触发暂停大模型的用户数量
+
+	LlmReplyLimitDuration Duration `toml:"llm_reply_limit_duration"` // 大模型最大回复数量的统计时间
+	LlmReplyLimitCount    int      `toml:"llm_reply_limit_count"`    // 大模型统计窗口内最大的回复数量
+
+	ProbabilityLlmTriggerDuration    Duration `toml:"probability_llm_trigger_duration"`     // 概率触发大模型回复的统计时间
+	ProbabilityLlmTriggerLevel1      float64  `toml:"probability_llm_trigger_level1"`       // 统计人数不超过 Level1Count 时不触发的概率
+	ProbabilityLlmTriggerLevel1Count int      `toml:"probability_llm_trigger_level1_count"` // 第一档统计人数
+	ProbabilityLlmTriggerLevel2      float64  `toml:"probability_llm_trigger_level2"`       // 统计人数不超过 Level2Count 时不触发的概率
+	ProbabilityLlmTriggerLevel2Count int      `toml:"probability_llm_trigger_level2_count"` // 第二档统计人数
+	ProbabilityLlmTriggerLevel3      float64  `toml:"probability_llm_trigger_level3"`       // 统计人数超过 Level2Count 时不触发的概率
+}
+
+var DefaultProfileConfig = ProfileConfig{
+	FansMedalName: "巫女酱",
+
+	LlmReplyFansMedalLevel:     10,
+	RoomEnterTTSFansMedalLevel: 15,
+
+	GiftComboDuration: Duration(4 * time.Second),
+
+	DisableLlmByUserCountDuration: Duration(1 * time.Minute),
+	DisableLlmByUserCount:         5,
+
+	LlmReplyLimitDuration: Duration(5 * time.Minute),
+	LlmReplyLimitCount:    10,
+
+	ProbabilityLlmTriggerDuration:    Duration(5 * time.Minute),
+	ProbabilityLlmTriggerLevel1:      0.0, // 100%触发
+	ProbabilityLlmTriggerLevel1Count: 0,
+	ProbabilityLlmTriggerLevel2:      0.3, // 70%触发
+	ProbabilityLlmTriggerLevel2Count: 10,
+	ProbabilityLlmTriggerLevel3:      0.7, // 30%触发
+}
+
+// Duration 支持在配置文件中使用 "4s"、"5m" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"time"
+)
+
+const watchDebounceDuration = 500 * time.Millisecond // 编辑器保存时可能触发多次事件，合并处理
+
+// Watcher 监听配置文件变更，解析校验通过后回调 onChange
+type Watcher struct {
+	filePath string
+	onChange func(cfg *Config) error
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func NewWatcher(filePath string, onChange func(cfg *Config) error) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// 监听所在目录，避免编辑器通过重命名保存文件后丢失监听
+	if err := fw.Add(filepath.Dir(filePath)); err != nil {
+		fw.Close()
+		return nil, err
+	}
+
+	w := &Watcher{
+		filePath: filepath.Clean(filePath),
+		onChange: onChange,
+		watcher:  fw,
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	debounceTimer := time.NewTimer(watchDebounceDuration)
+	debounceTimer.Stop()
+	defer debounceTimer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.filePath {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			debounceTimer.Reset(watchDebounceDuration)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("config watcher err: %v", err)
+		case <-debounceTimer.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	cfg, err := ParseConfig(w.filePath)
+	if err != nil {
+		log.Errorf("reload config %s rejected, parse err: %v", w.filePath, err)
+		return
+	}
+	if err := w.onChange(cfg); err != nil {
+		log.Errorf("reload config %s rejected, err: %v", w.filePath, err)
+		return
+	}
+	log.Infof("reload config %s success", w.filePath)
+}
+
+func (w *Watcher) Close() error {
+	err := w.watcher.Close()
+	<-w.done
+	return err
+}
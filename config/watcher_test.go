@@ -0,0 +1,51 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(filePath, []byte(`db_path = "a.db"`), 0644); err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+
+	ch := make(chan *Config, 4)
+	w, err := NewWatcher(filePath, func(cfg *Config) error {
+		ch <- cfg
+		return nil
+	})
+	if err != nil {
+		t.Errorf("NewWatcher err: %v", err)
+		return
+	}
+	defer w.Close()
+
+	// 无效的配置不会触发回调
+	if err := os.WriteFile(filePath, []byte(`db_path = `), 0644); err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+	select {
+	case <-ch:
+		t.Errorf("invalid config should be rejected")
+		return
+	case <-time.After(2 * watchDebounceDuration):
+	}
+
+	if err := os.WriteFile(filePath, []byte(`db_path = "b.db"`), 0644); err != nil {
+		t.Errorf("WriteFile err: %v", err)
+		return
+	}
+	select {
+	case cfg := <-ch:
+		assert.Equal(t, "b.db", cfg.DbPath)
+	case <-time.After(5 * time.Second):
+		t.Errorf("wait reload timeout")
+	}
+}
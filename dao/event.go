@@ -0,0 +1,148 @@
+package dao
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	EventTypeDanmu     = "danmu"
+	EventTypeSuperChat = "superchat"
+	EventTypeGift      = "gift"
+	EventTypeGuard     = "guard"
+
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Event 直播事件的公共字段
+type Event struct {
+	ID        uint64    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	SessionID string    `json:"session_id" gorm:"column:session_id;index"` // 直播间会话ID
+	RoomID    int       `json:"room_id" gorm:"column:room_id"`
+	OpenID    string    `json:"open_id" gorm:"column:open_id;index"`
+	Uname     string    `json:"uname" gorm:"column:uname"`
+	MsgID     string    `json:"msg_id" gorm:"column:msg_id"`
+	Time      time.Time `json:"time" gorm:"column:time;index"`
+}
+
+type DanmuEvent struct {
+	Event       `gorm:"embedded"`
+	Msg         string `json:"msg" gorm:"column:msg"`
+	EmojiImgUrl string `json:"emoji_img_url" gorm:"column:emoji_img_url"`
+	DmType      int    `json:"dm_type" gorm:"column:dm_type"`
+}
+
+func (DanmuEvent) TableName() string {
+	return "danmu_event"
+}
+
+type SuperChatEvent struct {
+	Event     `gorm:"embedded"`
+	Msg       string  `json:"msg" gorm:"column:msg"`
+	MessageID int     `json:"message_id" gorm:"column:message_id"`
+	Rmb       float64 `json:"rmb" gorm:"column:rmb"`
+}
+
+func (SuperChatEvent) TableName() string {
+	return "super_chat_event"
+}
+
+type GiftEvent struct {
+	Event    `gorm:"embedded"`
+	GiftID   int     `json:"gift_id" gorm:"column:gift_id"`
+	GiftName string  `json:"gift_name" gorm:"column:gift_name"`
+	GiftNum  int     `json:"gift_num" gorm:"column:gift_num"`
+	Rmb      float64 `json:"rmb" gorm:"column:rmb"` // 单价
+	Paid     bool    `json:"paid" gorm:"column:paid"`
+}
+
+func (GiftEvent) TableName() string {
+	return "gift_event"
+}
+
+type GuardEvent struct {
+	Event      `gorm:"embedded"`
+	GuardLevel int    `json:"guard_level" gorm:"column:guard_level"`
+	GuardNum   int    `json:"guard_num" gorm:"column:guard_num"`
+	GuardUnit  string `json:"guard_unit" gorm:"column:guard_unit"`
+}
+
+func (GuardEvent) TableName() string {
+	return "guard_event"
+}
+
+// CreateEvent 保存直播事件，event 为 *DanmuEvent、*SuperChatEvent、*GiftEvent 或 *GuardEvent
+func (d *Dao) CreateEvent(ctx context.Context, event interface{}) error {
+	return d.db.WithContext(ctx).Create(event).Error
+}
+
+type EventQuery struct {
+	SessionID string
+	OpenID    string
+	StartTime time.Time
+	EndTime   time.Time
+	Page      int // 从1开始
+	PageSize  int
+}
+
+type EventPage[T any] struct {
+	Total  int64 `json:"total"`
+	Events []*T  `json:"events"`
+}
+
+func listEvents[T any](ctx context.Context, d *Dao, q *EventQuery) (*EventPage[T], error) {
+	db := d.db.WithContext(ctx).Model(new(T))
+	if q.SessionID != "" {
+		db = db.Where("session_id = ?", q.SessionID)
+	}
+	if q.OpenID != "" {
+		db = db.Where("open_id = ?", q.OpenID)
+	}
+	if !q.StartTime.IsZero() {
+		db = db.Where("time >= ?", q.StartTime)
+	}
+	if !q.EndTime.IsZero() {
+		db = db.Where("time < ?", q.EndTime)
+	}
+
+	page := &EventPage[T]{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	pageNum, pageSize := q.Page, q.PageSize
+	if pageNum < 1 {
+		pageNum = 1
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	err := db.Order("time desc, id desc").
+		Offset((pageNum - 1) * pageSize).
+		Limit(pageSize).
+		Find(&page.Events).Error
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Dao) ListDanmuEvents(ctx context.Context, q *EventQuery) (*EventPage[DanmuEvent], error) {
+	return listEvents[DanmuEvent](ctx, d, q)
+}
+
+func (d *Dao) ListSuperChatEvents(ctx context.Context, q *EventQuery) (*EventPage[SuperChatEvent], error) {
+	return listEvents[SuperChatEvent](ctx, d, q)
+}
+
+func (d *Dao) ListGiftEvents(ctx context.Context, q *EventQuery) (*EventPage[GiftEvent], error) {
+	return listEvents[GiftEvent](ctx, d, q)
+}
+
+func (d *Dao) ListGuardEvents(ctx context.Context, q *EventQuery) (*EventPage[GuardEvent], error) {
+	return listEvents[GuardEvent](ctx, d, q)
+}
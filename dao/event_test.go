@@ -0,0 +1,53 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListEvents(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		openId := "a"
+		if i%2 == 1 {
+			openId = "b"
+		}
+		err := d.CreateEvent(ctx, &DanmuEvent{
+			Event: Event{
+				SessionID: "session",
+				OpenID:    openId,
+				Time:      now.Add(time.Duration(i) * time.Minute),
+			},
+			Msg: "msg",
+		})
+		assert.NoError(t, err)
+	}
+
+	page, err := d.ListDanmuEvents(ctx, &EventQuery{OpenID: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	assert.Len(t, page.Events, 3)
+
+	page, err = d.ListDanmuEvents(ctx, &EventQuery{
+		SessionID: "session",
+		StartTime: now.Add(time.Minute),
+		EndTime:   now.Add(3 * time.Minute),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), page.Total)
+
+	page, err = d.ListDanmuEvents(ctx, &EventQuery{Page: 2, PageSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), page.Total)
+	assert.Len(t, page.Events, 2)
+	assert.Equal(t, uint64(3), page.Events[0].ID)
+}
@@ -0,0 +1,111 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	FilterTypeWord  = "word"  // 屏蔽词，每行一个词，不区分大小写
+	FilterTypeRegex = "regex" // 正则表达式
+
+	FilterActionDrop    = "drop"     // 丢弃消息，不展示、不播报、不进入大模型
+	FilterActionMask    = "mask"     // 命中的内容替换为星号
+	FilterActionSkipTTS = "skip_tts" // 只跳过TTS
+	FilterActionSkipLLM = "skip_llm" // 只跳过大模型
+)
+
+var filterActionSet = map[string]struct{}{
+	FilterActionDrop:    {},
+	FilterActionMask:    {},
+	FilterActionSkipTTS: {},
+	FilterActionSkipLLM: {},
+}
+
+// FilterRule 过滤规则，对用户名和消息内容生效
+type FilterRule struct {
+	ID        uint64    `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	Type      string    `json:"type" gorm:"column:type"`
+	Pattern   string    `json:"pattern" gorm:"column:pattern"`
+	Action    string    `json:"action" gorm:"column:action"`
+	Disabled  bool      `json:"disabled" gorm:"column:disabled"`
+	Comment   string    `json:"comment" gorm:"column:comment"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (FilterRule) TableName() string {
+	return "filter_rule"
+}
+
+// Compile 编译为正则表达式，屏蔽词会转义后合并
+func (r *FilterRule) Compile() (*regexp.Regexp, error) {
+	if _, ok := filterActionSet[r.Action]; !ok {
+		return nil, fmt.Errorf("invalid filter action: %s", r.Action)
+	}
+
+	switch r.Type {
+	case FilterTypeWord:
+		var words []string
+		for _, w := range strings.Split(r.Pattern, "\n") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, regexp.QuoteMeta(w))
+			}
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("filter rule has no words")
+		}
+		return regexp.Compile("(?i)" + strings.Join(words, "|"))
+	case FilterTypeRegex:
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("filter rule pattern is empty")
+		}
+		return regexp.Compile(r.Pattern)
+	default:
+		return nil, fmt.Errorf("invalid filter type: %s", r.Type)
+	}
+}
+
+// FilterHit 过滤规则命中记录，供房管查看
+type FilterHit struct {
+	Event  `gorm:"embedded"`
+	RuleID uint64 `json:"rule_id" gorm:"column:rule_id;index"`
+	Action string `json:"action" gorm:"column:action"`
+	Text   string `json:"text" gorm:"column:text"` // 命中的原始内容
+}
+
+func (FilterHit) TableName() string {
+	return "filter_hit"
+}
+
+func (d *Dao) ListFilterRules(ctx context.Context) ([]*FilterRule, error) {
+	var rules []*FilterRule
+	err := d.db.WithContext(ctx).
+		Order("id").
+		Find(&rules).Error
+	return rules, err
+}
+
+// SaveFilterRule 创建或更新过滤规则，ID 为0时创建
+func (d *Dao) SaveFilterRule(ctx context.Context, rule *FilterRule) error {
+	if _, err := rule.Compile(); err != nil {
+		return err
+	}
+	return d.db.WithContext(ctx).Save(rule).Error
+}
+
+func (d *Dao) DeleteFilterRule(ctx context.Context, id uint64) error {
+	return d.db.WithContext(ctx).
+		Where("id = ?", id).
+		Delete(&FilterRule{}).Error
+}
+
+func (d *Dao) CreateFilterHit(ctx context.Context, hit *FilterHit) error {
+	return d.db.WithContext(ctx).Create(hit).Error
+}
+
+func (d *Dao) ListFilterHits(ctx context.Context, q *EventQuery) (*EventPage[FilterHit], error) {
+	return listEvents[FilterHit](ctx, d, q)
+}
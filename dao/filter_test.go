@@ -0,0 +1,46 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFilterRule(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+	ctx := context.Background()
+
+	rule := &FilterRule{Type: FilterTypeWord, Pattern: "笨蛋", Action: FilterActionMask}
+	assert.NoError(t, d.SaveFilterRule(ctx, rule))
+	assert.NotZero(t, rule.ID)
+
+	rule.Action = FilterActionDrop
+	assert.NoError(t, d.SaveFilterRule(ctx, rule))
+	rules, err := d.ListFilterRules(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, FilterActionDrop, rules[0].Action)
+
+	assert.Error(t, d.SaveFilterRule(ctx, &FilterRule{Type: FilterTypeRegex, Pattern: "(", Action: FilterActionDrop}))
+	assert.Error(t, d.SaveFilterRule(ctx, &FilterRule{Type: FilterTypeWord, Pattern: "a", Action: "unknown"}))
+
+	assert.NoError(t, d.CreateFilterHit(ctx, &FilterHit{
+		Event:  Event{SessionID: "s", OpenID: "a", Time: time.Now()},
+		RuleID: rule.ID,
+		Action: rule.Action,
+		Text:   "笨蛋",
+	}))
+	hits, err := d.ListFilterHits(ctx, &EventQuery{SessionID: "s"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), hits.Total)
+
+	assert.NoError(t, d.DeleteFilterRule(ctx, rule.ID))
+	rules, err = d.ListFilterRules(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+}
@@ -0,0 +1,73 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type LeaderboardEntry struct {
+	OpenID string  `json:"open_id" gorm:"column:open_id"`
+	Uname  string  `json:"uname" gorm:"column:uname"`
+	Rmb    float64 `json:"rmb,omitempty" gorm:"column:rmb"`     // 消费金额，付费礼物和醒目留言
+	Count  int     `json:"count,omitempty" gorm:"column:count"` // 弹幕和醒目留言数量
+}
+
+type LeaderboardQuery struct {
+	RoomID    int
+	StartTime time.Time // 为零值时不限制
+	EndTime   time.Time // 为零值时不限制
+	Limit     int
+}
+
+func (q *LeaderboardQuery) scope(db *gorm.DB) *gorm.DB {
+	db = db.Where("room_id = ?", q.RoomID)
+	if !q.StartTime.IsZero() {
+		db = db.Where("time >= ?", q.StartTime)
+	}
+	if !q.EndTime.IsZero() {
+		db = db.Where("time < ?", q.EndTime)
+	}
+	return db
+}
+
+// TopSpenders 消费排行，大航海没有价格信息不计入
+func (d *Dao) TopSpenders(ctx context.Context, q *LeaderboardQuery) ([]*LeaderboardEntry, error) {
+	db := d.db.WithContext(ctx)
+	gifts := db.Table(GiftEvent{}.TableName()).
+		Select("open_id, uname, rmb * gift_num AS rmb").
+		Where("paid").
+		Scopes(q.scope)
+	superChats := db.Table(SuperChatEvent{}.TableName()).
+		Select("open_id, uname, rmb").
+		Scopes(q.scope)
+
+	var entries []*LeaderboardEntry
+	err := db.Table("(?) AS spend", db.Raw("? UNION ALL ?", gifts, superChats)).
+		Select("open_id, MAX(uname) AS uname, SUM(rmb) AS rmb").
+		Group("open_id").
+		Order("rmb desc").
+		Limit(q.Limit).
+		Scan(&entries).Error
+	return entries, err
+}
+
+// TopChatters 发言排行
+func (d *Dao) TopChatters(ctx context.Context, q *LeaderboardQuery) ([]*LeaderboardEntry, error) {
+	db := d.db.WithContext(ctx)
+	danmus := db.Table(DanmuEvent{}.TableName()).
+		Select("open_id, uname").
+		Scopes(q.scope)
+	superChats := db.Table(SuperChatEvent{}.TableName()).
+		Select("open_id, uname").
+		Scopes(q.scope)
+
+	var entries []*LeaderboardEntry
+	err := db.Table("(?) AS chat", db.Raw("? UNION ALL ?", danmus, superChats)).
+		Select("open_id, MAX(uname) AS uname, COUNT(*) AS count").
+		Group("open_id").
+		Order("count desc").
+		Limit(q.Limit).
+		Scan(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLeaderboard(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	event := func(openId string, t time.Time) Event {
+		return Event{RoomID: 1, OpenID: openId, Uname: openId, Time: t}
+	}
+	events := []interface{}{
+		&GiftEvent{Event: event("a", now), GiftNum: 2, Rmb: 10, Paid: true},
+		&GiftEvent{Event: event("b", now), GiftNum: 100, Rmb: 0.1},
+		&SuperChatEvent{Event: event("b", now), Rmb: 30},
+		&SuperChatEvent{Event: event("c", now.Add(-48*time.Hour)), Rmb: 1000},
+		&DanmuEvent{Event: event("a", now)},
+		&DanmuEvent{Event: event("a", now)},
+		&DanmuEvent{Event: event("a", now)},
+		&DanmuEvent{Event: event("c", now)},
+	}
+	for _, e := range events {
+		assert.NoError(t, d.CreateEvent(ctx, e))
+	}
+
+	spenders, err := d.TopSpenders(ctx, &LeaderboardQuery{
+		RoomID:    1,
+		StartTime: now.Add(-24 * time.Hour),
+		Limit:     10,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, spenders, 2) {
+		assert.Equal(t, "b", spenders[0].OpenID)
+		assert.Equal(t, 30.0, spenders[0].Rmb)
+		assert.Equal(t, "a", spenders[1].OpenID)
+		assert.Equal(t, 20.0, spenders[1].Rmb)
+	}
+
+	spenders, err = d.TopSpenders(ctx, &LeaderboardQuery{RoomID: 1, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, spenders, 1) {
+		assert.Equal(t, "c", spenders[0].OpenID)
+	}
+
+	chatters, err := d.TopChatters(ctx, &LeaderboardQuery{RoomID: 1, Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, chatters, 3) {
+		assert.Equal(t, "a", chatters[0].OpenID)
+		assert.Equal(t, 3, chatters[0].Count)
+	}
+}
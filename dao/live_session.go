@@ -0,0 +1,157 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// LiveSession 一场直播，开播时创建，下播时根据直播事件统计营收
+type LiveSession struct {
+	ID        uint64     `json:"id" gorm:"column:id;primarykey;autoIncrement"`
+	RoomID    int        `json:"room_id" gorm:"column:room_id;index"`
+	StartTime time.Time  `json:"start_time" gorm:"column:start_time"`
+	EndTime   *time.Time `json:"end_time" gorm:"column:end_time"`
+
+	PaidGiftRmb  float64 `json:"paid_gift_rmb" gorm:"column:paid_gift_rmb"`   // 付费礼物总价值
+	FreeGiftRmb  float64 `json:"free_gift_rmb" gorm:"column:free_gift_rmb"`   // 免费礼物总价值
+	SuperChatRmb float64 `json:"super_chat_rmb" gorm:"column:super_chat_rmb"` // 醒目留言总金额
+	GuardCount   int     `json:"guard_count" gorm:"column:guard_count"`       // 大航海总数
+	Guard1Count  int     `json:"guard1_count" gorm:"column:guard1_count"`     // 总督数量
+	Guard2Count  int     `json:"guard2_count" gorm:"column:guard2_count"`     // 提督数量
+	Guard3Count  int     `json:"guard3_count" gorm:"column:guard3_count"`     // 舰长数量
+	DanmuCount   int     `json:"danmu_count" gorm:"column:danmu_count"`       // 弹幕数量
+	ChatterCount int     `json:"chatter_count" gorm:"column:chatter_count"`   // 发送弹幕和醒目留言的用户数量
+}
+
+func (LiveSession) TableName() string {
+	return "live_session"
+}
+
+func (d *Dao) StartLiveSession(ctx context.Context, roomId int, startTime time.Time) (*LiveSession, error) {
+	ls := &LiveSession{
+		RoomID:    roomId,
+		StartTime: startTime,
+	}
+	if err := d.db.WithContext(ctx).Create(ls).Error; err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// GetOpenLiveSession 获取房间最近一场未结束的直播，没有时返回 nil
+func (d *Dao) GetOpenLiveSession(ctx context.Context, roomId int) (*LiveSession, error) {
+	ls := &LiveSession{}
+	err := d.db.WithContext(ctx).
+		Where("room_id = ? AND end_time IS NULL", roomId).
+		Order("start_time desc").
+		First(ls).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ls, nil
+}
+
+// GetLatestLiveSession 获取房间最近一场直播，包括未结束的直播，没有时返回 nil
+func (d *Dao) GetLatestLiveSession(ctx context.Context, roomId int) (*LiveSession, error) {
+	ls := &LiveSession{}
+	err := d.db.WithContext(ctx).
+		Where("room_id = ?", roomId).
+		Order("start_time desc").
+		First(ls).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ls, nil
+}
+
+// EndLiveSession 结束直播并统计直播期间的事件
+func (d *Dao) EndLiveSession(ctx context.Context, ls *LiveSession, endTime time.Time) error {
+	ls.EndTime = &endTime
+	if err := d.SummarizeLiveSession(ctx, ls); err != nil {
+		return err
+	}
+	return d.db.WithContext(ctx).Save(ls).Error
+}
+
+// SummarizeLiveSession 根据直播事件统计营收，未结束的直播统计到当前时间
+func (d *Dao) SummarizeLiveSession(ctx context.Context, ls *LiveSession) error {
+	endTime := time.Now()
+	if ls.EndTime != nil {
+		endTime = *ls.EndTime
+	}
+	db := d.db.WithContext(ctx)
+	inRange := func(table string) *gorm.DB {
+		return db.Table(table).Where("room_id = ? AND time >= ? AND time <= ?", ls.RoomID, ls.StartTime, endTime)
+	}
+
+	var gift struct {
+		PaidGiftRmb float64
+		FreeGiftRmb float64
+	}
+	err := inRange(GiftEvent{}.TableName()).
+		Select("COALESCE(SUM(CASE WHEN paid THEN rmb * gift_num ELSE 0 END), 0) AS paid_gift_rmb, " +
+			"COALESCE(SUM(CASE WHEN paid THEN 0 ELSE rmb * gift_num END), 0) AS free_gift_rmb").
+		Scan(&gift).Error
+	if err != nil {
+		return err
+	}
+
+	var superChatRmb float64
+	err = inRange(SuperChatEvent{}.TableName()).
+		Select("COALESCE(SUM(rmb), 0)").
+		Scan(&superChatRmb).Error
+	if err != nil {
+		return err
+	}
+
+	var guard struct {
+		GuardCount  int
+		Guard1Count int
+		Guard2Count int
+		Guard3Count int
+	}
+	err = inRange(GuardEvent{}.TableName()).
+		Select("COALESCE(SUM(guard_num), 0) AS guard_count, " +
+			"COALESCE(SUM(CASE WHEN guard_level = 1 THEN guard_num ELSE 0 END), 0) AS guard1_count, " +
+			"COALESCE(SUM(CASE WHEN guard_level = 2 THEN guard_num ELSE 0 END), 0) AS guard2_count, " +
+			"COALESCE(SUM(CASE WHEN guard_level = 3 THEN guard_num ELSE 0 END), 0) AS guard3_count").
+		Scan(&guard).Error
+	if err != nil {
+		return err
+	}
+
+	var danmuCount int64
+	if err := inRange(DanmuEvent{}.TableName()).Count(&danmuCount).Error; err != nil {
+		return err
+	}
+
+	var chatterCount int64
+	err = db.Table("(?) AS chatter",
+		db.Raw("? UNION ?",
+			inRange(DanmuEvent{}.TableName()).Select("open_id"),
+			inRange(SuperChatEvent{}.TableName()).Select("open_id"),
+		),
+	).Count(&chatterCount).Error
+	if err != nil {
+		return err
+	}
+
+	ls.PaidGiftRmb = gift.PaidGiftRmb
+	ls.FreeGiftRmb = gift.FreeGiftRmb
+	ls.SuperChatRmb = superChatRmb
+	ls.GuardCount = guard.GuardCount
+	ls.Guard1Count = guard.Guard1Count
+	ls.Guard2Count = guard.Guard2Count
+	ls.Guard3Count = guard.Guard3Count
+	ls.DanmuCount = int(danmuCount)
+	ls.ChatterCount = int(chatterCount)
+	return nil
+}
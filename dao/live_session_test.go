@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLiveSession(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	const roomId = 1
+	start := time.Now().Add(-time.Hour)
+	ls, err := d.StartLiveSession(ctx, roomId, start)
+	assert.NoError(t, err)
+
+	open, err := d.GetOpenLiveSession(ctx, roomId)
+	assert.NoError(t, err)
+	assert.Equal(t, ls.ID, open.ID)
+
+	event := func(openId string) Event {
+		return Event{RoomID: roomId, OpenID: openId, Time: start.Add(time.Minute)}
+	}
+	events := []interface{}{
+		&DanmuEvent{Event: event("a")},
+		&DanmuEvent{Event: event("a")},
+		&DanmuEvent{Event: event("b")},
+		&SuperChatEvent{Event: event("c"), Rmb: 30},
+		&GiftEvent{Event: event("a"), GiftNum: 2, Rmb: 1.5, Paid: true},
+		&GiftEvent{Event: event("b"), GiftNum: 10, Rmb: 0.1},
+		&GuardEvent{Event: event("d"), GuardLevel: 3, GuardNum: 2},
+		&GuardEvent{Event: event("e"), GuardLevel: 2, GuardNum: 1},
+		// 其他房间和直播开始前的事件不统计
+		&DanmuEvent{Event: Event{RoomID: 2, OpenID: "f", Time: start.Add(time.Minute)}},
+		&DanmuEvent{Event: Event{RoomID: roomId, OpenID: "g", Time: start.Add(-time.Minute)}},
+	}
+	for _, e := range events {
+		assert.NoError(t, d.CreateEvent(ctx, e))
+	}
+
+	assert.NoError(t, d.EndLiveSession(ctx, ls, time.Now()))
+	assert.Equal(t, 3.0, ls.PaidGiftRmb)
+	assert.InDelta(t, 1.0, ls.FreeGiftRmb, 0.0001)
+	assert.Equal(t, 30.0, ls.SuperChatRmb)
+	assert.Equal(t, 3, ls.GuardCount)
+	assert.Equal(t, 1, ls.Guard2Count)
+	assert.Equal(t, 2, ls.Guard3Count)
+	assert.Equal(t, 3, ls.DanmuCount)
+	assert.Equal(t, 3, ls.ChatterCount)
+
+	open, err = d.GetOpenLiveSession(ctx, roomId)
+	assert.NoError(t, err)
+	assert.Nil(t, open)
+}
@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// UserMute 禁止用户的消息触发TTS和进入大模型历史，弹幕仍然会展示
+type UserMute struct {
+	OpenID    string     `json:"open_id" gorm:"column:open_id;primarykey"`
+	Uname     string     `json:"uname" gorm:"column:uname"`
+	Reason    string     `json:"reason" gorm:"column:reason"`
+	ExpireAt  *time.Time `json:"expire_at" gorm:"column:expire_at"` // 为空时永久禁止
+	UpdatedAt time.Time  `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (UserMute) TableName() string {
+	return "user_mute"
+}
+
+func (m *UserMute) Expired(now time.Time) bool {
+	return m.ExpireAt != nil && !now.Before(*m.ExpireAt)
+}
+
+// IsMuted 用户是否被禁止TTS，过期的记录视为未禁止
+func (d *Dao) IsMuted(ctx context.Context, openId string) (bool, error) {
+	d.muteMapMutex.RLock()
+	mute, ok := d.muteMap[openId]
+	d.muteMapMutex.RUnlock()
+
+	if !ok {
+		mute = &UserMute{}
+		err := d.db.WithContext(ctx).
+			Where("open_id = ?", openId).
+			First(mute).Error
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, err
+			}
+			mute = nil
+		}
+
+		d.muteMapMutex.Lock()
+		d.muteMap[openId] = mute
+		d.muteMapMutex.Unlock()
+	}
+
+	return mute != nil && !mute.Expired(time.Now()), nil
+}
+
+func (d *Dao) SetMute(ctx context.Context, mute *UserMute) error {
+	err := d.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(mute).Error
+	if err != nil {
+		return err
+	}
+
+	d.muteMapMutex.Lock()
+	d.muteMap[mute.OpenID] = mute
+	d.muteMapMutex.Unlock()
+	return nil
+}
+
+func (d *Dao) DeleteMute(ctx context.Context, openId string) error {
+	err := d.db.WithContext(ctx).
+		Where("open_id = ?", openId).
+		Delete(&UserMute{}).Error
+	if err != nil {
+		return err
+	}
+
+	d.muteMapMutex.Lock()
+	d.muteMap[openId] = nil
+	d.muteMapMutex.Unlock()
+	return nil
+}
+
+// ListMutes 列出未过期的禁止记录
+func (d *Dao) ListMutes(ctx context.Context) ([]*UserMute, error) {
+	var mutes []*UserMute
+	err := d.db.WithContext(ctx).
+		Where("expire_at IS NULL OR expire_at > ?", time.Now()).
+		Order("updated_at desc").
+		Find(&mutes).Error
+	return mutes, err
+}
@@ -0,0 +1,42 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMute(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+	ctx := context.Background()
+
+	muted, err := d.IsMuted(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, muted)
+
+	assert.NoError(t, d.SetMute(ctx, &UserMute{OpenID: "a", Uname: "a"}))
+	muted, err = d.IsMuted(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, muted)
+
+	expired := time.Now().Add(-time.Minute)
+	assert.NoError(t, d.SetMute(ctx, &UserMute{OpenID: "b", ExpireAt: &expired}))
+	muted, err = d.IsMuted(ctx, "b")
+	assert.NoError(t, err)
+	assert.False(t, muted)
+
+	mutes, err := d.ListMutes(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, mutes, 1)
+	assert.Equal(t, "a", mutes[0].OpenID)
+
+	assert.NoError(t, d.DeleteMute(ctx, "a"))
+	muted, err = d.IsMuted(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, muted)
+}
@@ -0,0 +1,109 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	RoleOwner     = "owner"     // 主播，可以执行所有指令
+	RoleModerator = "moderator" // 房管，可以执行指令、强制触发大模型响应
+	RoleVIP       = "vip"       // 不需要粉丝牌即可触发大模型响应
+	RoleBlocked   = "blocked"   // 屏蔽，消息照常保存但不展示，不会触发TTS和大模型
+)
+
+var roleSet = map[string]struct{}{
+	RoleOwner:     {},
+	RoleModerator: {},
+	RoleVIP:       {},
+	RoleBlocked:   {},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleSet[role]
+	return ok
+}
+
+type UserRole struct {
+	OpenID    string    `json:"open_id" gorm:"column:open_id;primarykey"`
+	Uname     string    `json:"uname" gorm:"column:uname"`
+	Role      string    `json:"role" gorm:"column:role"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (UserRole) TableName() string {
+	return "user_role"
+}
+
+// GetRole 获取用户角色，没有角色时返回空字符串
+func (d *Dao) GetRole(ctx context.Context, openId string) (string, error) {
+	d.roleMapMutex.RLock()
+	userRole, ok := d.roleMap[openId]
+	d.roleMapMutex.RUnlock()
+
+	if !ok {
+		userRole = &UserRole{}
+		err := d.db.WithContext(ctx).
+			Where("open_id = ?", openId).
+			First(userRole).Error
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", err
+			}
+			userRole = nil
+		}
+
+		d.roleMapMutex.Lock()
+		d.roleMap[openId] = userRole
+		d.roleMapMutex.Unlock()
+	}
+
+	if userRole == nil {
+		return "", nil
+	}
+	return userRole.Role, nil
+}
+
+func (d *Dao) SetRole(ctx context.Context, userRole *UserRole) error {
+	if !IsValidRole(userRole.Role) {
+		return fmt.Errorf("invalid role: %s", userRole.Role)
+	}
+
+	err := d.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(userRole).Error
+	if err != nil {
+		return err
+	}
+
+	d.roleMapMutex.Lock()
+	d.roleMap[userRole.OpenID] = userRole
+	d.roleMapMutex.Unlock()
+	return nil
+}
+
+func (d *Dao) DeleteRole(ctx context.Context, openId string) error {
+	err := d.db.WithContext(ctx).
+		Where("open_id = ?", openId).
+		Delete(&UserRole{}).Error
+	if err != nil {
+		return err
+	}
+
+	d.roleMapMutex.Lock()
+	d.roleMap[openId] = nil
+	d.roleMapMutex.Unlock()
+	return nil
+}
+
+func (d *Dao) ListRoles(ctx context.Context) ([]*UserRole, error) {
+	var userRoles []*UserRole
+	err := d.db.WithContext(ctx).
+		Order("updated_at desc").
+		Find(&userRoles).Error
+	return userRoles, err
+}
@@ -0,0 +1,40 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRole(t *testing.T) {
+	d, err := NewDao(MemoryFilePath)
+	if err != nil {
+		t.Errorf("NewDao err: %v", err)
+		return
+	}
+
+	const openId = "test"
+	ctx := context.Background()
+
+	role, err := d.GetRole(ctx, openId)
+	assert.NoError(t, err)
+	assert.Equal(t, "", role)
+
+	assert.NoError(t, d.SetRole(ctx, &UserRole{OpenID: openId, Uname: "test", Role: RoleModerator}))
+	role, err = d.GetRole(ctx, openId)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleModerator, role)
+
+	assert.NoError(t, d.SetRole(ctx, &UserRole{OpenID: openId, Uname: "test", Role: RoleBlocked}))
+	roles, err := d.ListRoles(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	assert.Equal(t, RoleBlocked, roles[0].Role)
+
+	assert.Error(t, d.SetRole(ctx, &UserRole{OpenID: openId, Role: "unknown"}))
+
+	assert.NoError(t, d.DeleteRole(ctx, openId))
+	role, err = d.GetRole(ctx, openId)
+	assert.NoError(t, err)
+	assert.Equal(t, "", role)
+}
@@ -14,6 +14,12 @@ type Dao struct {
 
 	userMap      map[string]*User
 	userMapMutex sync.RWMutex
+
+	roleMap      map[string]*UserRole
+	roleMapMutex sync.RWMutex
+
+	muteMap      map[string]*UserMute
+	muteMapMutex sync.RWMutex
 }
 
 const MemoryFilePath = ":memory:"
@@ -39,12 +45,14 @@ func NewDao(filePath string) (*Dao, error) {
 		return nil, err
 	}
 
-	if err := db.AutoMigrate(User{}); err != nil {
+	if err := db.AutoMigrate(User{}, UserRole{}, DanmuEvent{}, SuperChatEvent{}, GiftEvent{}, GuardEvent{}, LiveSession{}, FilterRule{}, FilterHit{}, UserMute{}); err != nil {
 		return nil, err
 	}
 
 	return &Dao{
 		db:      db,
 		userMap: make(map[string]*User),
+		roleMap: make(map[string]*UserRole),
+		muteMap: make(map[string]*UserMute),
 	}, nil
 }
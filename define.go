@@ -1,9 +1,12 @@
 package main
 
 const (
-	RequestTypeInit      = "init"
-	RequestTypeHeartbeat = "heartbeat"
-	RequestTypeConfig    = "config"
+	RequestTypeInit       = "init"
+	RequestTypeHeartbeat  = "heartbeat"
+	RequestTypeConfig     = "config"
+	RequestTypeMute       = "mute"
+	RequestTypeTTSPlayed  = "tts_played"  // 前端播放完一条TTS
+	RequestTypeTTSSkipped = "tts_skipped" // 前端跳过一条TTS
 
 	ResultTypeHeartbeat = "heartbeat"
 	ResultTypeRoom      = "room"
@@ -14,8 +17,19 @@ const (
 	ResultTypeGuard     = "guard"
 	ResultTypeEnterRoom = "enter_room"
 
-	ResultTypeTTS = "tts"
-	ResultTypeLLM = "llm"
+	ResultTypeLiveSummary = "live_summary"
+	ResultTypeLeaderboard = "leaderboard"
+
+	ResultTypeTTS     = "tts"
+	ResultTypeTTSDrop = "tts_drop"
+	ResultTypeTTSSkip = "tts_skip" // 通知前端停止播放
+
+	ResultTypeTTSStart  = "tts_start"
+	ResultTypeTTSEnd    = "tts_end"
+	ResultTypeTTSStatus = "tts_status"
+	ResultTypeLLM       = "llm"
+	ResultTypeCommand   = "command"
+	ResultTypeMute      = "mute"
 
 	CmdLiveRoomEnter = "LIVE_OPEN_PLATFORM_LIVE_ROOM_ENTER"
 	CmdLiveStart     = "LIVE_OPEN_PLATFORM_LIVE_START"
@@ -62,12 +76,22 @@ type InitRequestData struct {
 	Caller    string     `json:"caller"`
 	CodeSign  string     `json:"code_sign"`
 	Config    LiveConfig `json:"config"`
+
+	StreamAudio bool   `json:"stream_audio"` // 通过二进制帧接收TTS音频
+	PlayAudio   bool   `json:"play_audio"`   // 播放TTS音频，同一个直播间只有第一个请求播放的连接会收到音频
+	AdminToken  string `json:"admin_token"`  // 管理接口的 Token，携带后可以发送 mute 请求
+}
+
+// TTSAckRequestData 前端确认播放完成或者跳过
+type TTSAckRequestData struct {
+	TaskId string `json:"task_id"`
 }
 
 type RoomData struct {
-	RoomID int    `json:"room_id"`
-	Uname  string `json:"uname"`
-	UFace  string `json:"uface"`
+	SessionID string `json:"session_id"`
+	RoomID    int    `json:"room_id"`
+	Uname     string `json:"uname"`
+	UFace     string `json:"uface"`
 }
 
 type UserData struct {
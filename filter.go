@@ -0,0 +1,225 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type filterRule struct {
+	*dao.FilterRule
+	re *regexp.Regexp
+}
+
+// Filter 编译后的过滤规则
+type Filter struct {
+	rules []*filterRule
+}
+
+// FilterResult 过滤结果，Text 为 mask 之后的内容
+type FilterResult struct {
+	Text    string
+	Drop    bool
+	SkipTTS bool
+	SkipLLM bool
+	Hits    []*dao.FilterRule
+}
+
+func (r *FilterResult) merge(o *FilterResult) {
+	r.Drop = r.Drop || o.Drop
+	r.SkipTTS = r.SkipTTS || o.SkipTTS
+	r.SkipLLM = r.SkipLLM || o.SkipLLM
+	r.Hits = append(r.Hits, o.Hits...)
+}
+
+// NewFilter 编译过滤规则，跳过禁用的规则
+func NewFilter(rules []*dao.FilterRule) (*Filter, error) {
+	f := &Filter{}
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		re, err := rule.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("compile filter rule %d err: %w", rule.ID, err)
+		}
+		f.rules = append(f.rules, &filterRule{FilterRule: rule, re: re})
+	}
+	return f, nil
+}
+
+// Apply 按顺序匹配所有规则
+func (f *Filter) Apply(text string) *FilterResult {
+	res := &FilterResult{Text: text}
+	for _, rule := range f.rules {
+		if !rule.re.MatchString(res.Text) {
+			continue
+		}
+		res.Hits = append(res.Hits, rule.FilterRule)
+		switch rule.Action {
+		case dao.FilterActionDrop:
+			res.Drop = true
+		case dao.FilterActionMask:
+			res.Text = rule.re.ReplaceAllStringFunc(res.Text, func(s string) string {
+				return strings.Repeat("*", len([]rune(s)))
+			})
+		case dao.FilterActionSkipTTS:
+			res.SkipTTS = true
+		case dao.FilterActionSkipLLM:
+			res.SkipLLM = true
+		}
+	}
+	return res
+}
+
+func (h *Handler) Filter() *Filter { return h.filter.Load() }
+
+// ReloadFilter 从数据库重新加载过滤规则
+func (h *Handler) ReloadFilter(ctx context.Context) error {
+	rules, err := h.Dao.ListFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := NewFilter(rules)
+	if err != nil {
+		return err
+	}
+	h.filter.Store(f)
+	return nil
+}
+
+// applyFilter 过滤用户名和消息内容，mask 会直接修改 u.Uname 和 msg，命中记录保存到数据库
+// 被禁止TTS的用户会同时跳过TTS和大模型，黑名单用户的事件照常保存，但是和命中 drop 规则一样不展示
+func (s *Session) applyFilter(u *UserData, msgId string, msg *string) *FilterResult {
+	f := s.h.Filter()
+	hitUser := *u
+
+	res := f.Apply(u.Uname)
+	saveHits := func(r *FilterResult, text string) {
+		for _, rule := range r.Hits {
+			log.Infof("filter rule %d (%s) hit, open_id: %s, uname: %s, text: %s", rule.ID, rule.Action, hitUser.OpenID, hitUser.Uname, text)
+			hit := &dao.FilterHit{
+				Event:  s.newEvent(hitUser, msgId, 0),
+				RuleID: rule.ID,
+				Action: rule.Action,
+				Text:   text,
+			}
+			s.persist(func() {
+				s.h.saveFilterHit(hit)
+			})
+		}
+	}
+	saveHits(res, u.Uname)
+	u.Uname = res.Text
+
+	if msg != nil {
+		msgRes := f.Apply(*msg)
+		saveHits(msgRes, *msg)
+		*msg = msgRes.Text
+		res.merge(msgRes)
+	}
+
+	if s.isMuted(hitUser.OpenID) {
+		res.SkipTTS = true
+		res.SkipLLM = true
+	}
+	if s.getRole(hitUser.OpenID) == dao.RoleBlocked {
+		res.Drop = true
+	}
+	return res
+}
+
+func (h *Handler) saveFilterHit(hit *dao.FilterHit) {
+	if err := h.Dao.CreateFilterHit(context.Background(), hit); err != nil {
+		log.Errorf("CreateFilterHit err: %v", err)
+	}
+}
+
+func (h *Handler) ListFilterRules(c *gin.Context) {
+	rules, err := h.Dao.ListFilterRules(c.Request.Context())
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, rules)
+}
+
+type SaveFilterRuleRequest struct {
+	ID       uint64 `json:"id"` // 为0时创建
+	Type     string `json:"type" binding:"required"`
+	Pattern  string `json:"pattern" binding:"required"`
+	Action   string `json:"action" binding:"required"`
+	Disabled bool   `json:"disabled"`
+	Comment  string `json:"comment"`
+}
+
+func (h *Handler) SaveFilterRule(c *gin.Context) {
+	var req SaveFilterRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	rule := &dao.FilterRule{
+		ID:       req.ID,
+		Type:     req.Type,
+		Pattern:  req.Pattern,
+		Action:   req.Action,
+		Disabled: req.Disabled,
+		Comment:  req.Comment,
+	}
+	if _, err := rule.Compile(); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	ctx := c.Request.Context()
+	if err := h.Dao.SaveFilterRule(ctx, rule); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	if err := h.ReloadFilter(ctx); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, rule)
+}
+
+func (h *Handler) DeleteFilterRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, "invalid id")
+		return
+	}
+	ctx := c.Request.Context()
+	if err := h.Dao.DeleteFilterRule(ctx, id); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	if err := h.ReloadFilter(ctx); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, nil)
+}
+
+// ListFilterHits 分页查询过滤规则命中记录
+func (h *Handler) ListFilterHits(c *gin.Context) {
+	var req ListEventsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	page, err := h.Dao.ListFilterHits(c.Request.Context(), req.query())
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, page)
+}
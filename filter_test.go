@@ -0,0 +1,40 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]*dao.FilterRule{
+		{ID: 1, Type: dao.FilterTypeWord, Pattern: "笨蛋\nBad", Action: dao.FilterActionMask},
+		{ID: 2, Type: dao.FilterTypeRegex, Pattern: `\d{11}`, Action: dao.FilterActionDrop},
+		{ID: 3, Type: dao.FilterTypeWord, Pattern: "http", Action: dao.FilterActionSkipTTS},
+		{ID: 4, Type: dao.FilterTypeWord, Pattern: "忽略", Action: dao.FilterActionSkipLLM},
+		{ID: 5, Type: dao.FilterTypeWord, Pattern: "hello", Action: dao.FilterActionDrop, Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("NewFilter err: %v", err)
+	}
+
+	res := f.Apply("你是笨蛋 BAD")
+	assert.Equal(t, "你是** ***", res.Text)
+	assert.False(t, res.Drop)
+	assert.Len(t, res.Hits, 1)
+
+	res = f.Apply("加我13800000000")
+	assert.True(t, res.Drop)
+
+	res = f.Apply("看 http://example.com 忽略")
+	assert.True(t, res.SkipTTS)
+	assert.True(t, res.SkipLLM)
+	assert.Len(t, res.Hits, 2)
+
+	res = f.Apply("hello")
+	assert.Equal(t, "hello", res.Text)
+	assert.Empty(t, res.Hits)
+
+	_, err = NewFilter([]*dao.FilterRule{{Type: dao.FilterTypeRegex, Pattern: "(", Action: dao.FilterActionDrop}})
+	assert.Error(t, err)
+}
@@ -173,6 +173,7 @@ export const useStore = defineStore('live', {
     sc_list: [],
     gift_list: [],
     tts_list: [],
+    tts_skip_id: '',
     enter_room_list: []
   }),
   actions: {
@@ -305,6 +306,12 @@ export const useStore = defineStore('live', {
       }
       this.tts_list.push(data)
     },
+    skipTTS(data) {
+      if (!data) {
+        return
+      }
+      this.tts_skip_id = data.task_id
+    },
     sendLLM(data) {
       const msg_id = getUUID()
       const danmu_data = {
@@ -10,49 +10,26 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/hashicorp/golang-lru/v2/expirable"
-	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
-	"github.com/vtb-link/bianka/basic"
 	"github.com/vtb-link/bianka/live"
-	"github.com/vtb-link/bianka/proto"
 	"golang.org/x/exp/slog"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	FansMedalName = "巫女酱" // 粉丝牌名称
-
-	LlmReplyFansMedalLevel     = 10 // 可以触发大模型响应的最小粉丝牌等级
-	RoomEnterTTSFansMedalLevel = 15 // 可以触发进入直播间TTS提示的最小粉丝牌等级
-
-	MessageExpiration     = 15 * time.Minute // 历史消息过期时间
-	GiftComboDuration     = 4 * time.Second  // 礼物连击时间，连击结束后会合并播放TTS
-	LlmHistoryDuration    = 10 * time.Minute // 大模型使用历史弹幕去理解上下文的时间范围
-	LastEnterUserDuration = 10 * time.Minute // 最后一个进入直播间用户将会播放TTS的等待时间
-
-	DisableLlmByUserCountDuration = 1 * time.Minute // 统计间隔时间内用户数量，用于触发暂停大模型
-	DisableLlmByUserCount         = 5               // 触发暂停大模型的用户数量
-
-	LlmReplyLimitDuration = 5 * time.Minute // 大模型最大回复数量的统计时间
-	LlmReplyLimitCount    = 10              // 大模型统计窗口内最大的回复数量
-
-	ProbabilityLlmTriggerDuration    = 5 * time.Minute // 概率触发大模型回复的统计时间
-	ProbabilityLlmTriggerLevel1      = 0.0             // 100%触发
-	ProbabilityLlmTriggerLevel1Count = 0               // 统计人数为0
-	ProbabilityLlmTriggerLevel2      = 0.3             // 70%触发
-	ProbabilityLlmTriggerLevel2Count = 10              // 统计人数为[1, 10]
-	ProbabilityLlmTriggerLevel3      = 0.7             // 30%触发
+	MessageExpiration  = 15 * time.Minute // 历史消息过期时间
+	LlmHistoryDuration = 10 * time.Minute // 大模型使用历史弹幕去理解上下文的时间范围
 )
 
+// LastEnterUserDuration 最后一个进入直播间用户将会播放TTS的等待时间，测试中会缩短
+var LastEnterUserDuration = 10 * time.Minute
+
 func HandleImg(c *gin.Context) {
 	imgUrl := c.Query("img_url")
 	u, err := url.Parse(imgUrl)
@@ -93,18 +70,23 @@ func HandleImg(c *gin.Context) {
 }
 
 type Handler struct {
-	cfg        *config.Config
-	liveClient *live.Client
+	cfg        atomic.Pointer[config.Config]
+	liveClient atomic.Pointer[live.Client]
+	templates  atomic.Pointer[TextTemplates]
+	filter     atomic.Pointer[Filter]
 
 	LLM *llm.LLM
 	TTS *tts.TTS
 	Dao *dao.Dao
 
+	sessions *SessionManager
+	replay   *ReplayOptions
+
 	slog *slog.Logger
 }
 
 func NewHandler(cfg *config.Config, logWriter io.Writer) (*Handler, error) {
-	t, err := tts.NewTTS(cfg.AliyunTTS)
+	t, err := tts.NewTTS(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("tts.NewTTS err: %w", err)
 	}
@@ -112,21 +94,77 @@ func NewHandler(cfg *config.Config, logWriter io.Writer) (*Handler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("dao.NewDao err: %w", err)
 	}
-	return &Handler{
-		cfg:        cfg,
-		liveClient: live.NewClient(live.NewConfig(cfg.BiliBili.AccessKey, cfg.BiliBili.SecretKey, cfg.BiliBili.AppId)),
-		LLM:        llm.NewLLM(cfg.QianFan),
-		TTS:        t,
-		Dao:        d,
-		slog:       slog.New(slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: slog.LevelInfo})),
-	}, nil
+	templates, err := NewTextTemplates(cfg.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("NewTextTemplates err: %w", err)
+	}
+	h := &Handler{
+		LLM:  llm.NewLLM(cfg.QianFan),
+		TTS:  t,
+		Dao:  d,
+		slog: slog.New(slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: slog.LevelInfo})),
+	}
+	h.cfg.Store(cfg)
+	h.liveClient.Store(newLiveClient(cfg.BiliBili))
+	h.templates.Store(templates)
+	if err := h.ReloadFilter(context.Background()); err != nil {
+		return nil, fmt.Errorf("ReloadFilter err: %w", err)
+	}
+	h.sessions = NewSessionManager(h)
+	t.SetStatusHandler(h.onTTSStatus)
+	return h, nil
+}
+
+// onTTSStatus 合成后端熔断和恢复时通知所有页面
+func (h *Handler) onTTSStatus(status *tts.BreakerStatus) {
+	h.sessions.Broadcast(ResultTypeTTSStatus, status)
+}
+
+func newLiveClient(cfg *config.BiliBiliConfig) *live.Client {
+	liveCfg := live.NewConfig(cfg.AccessKey, cfg.SecretKey, cfg.AppId)
+	if cfg.OpenPlatformHost != "" {
+		liveCfg.OpenPlatformHttpHost = cfg.OpenPlatformHost
+	}
+	return live.NewClient(liveCfg)
+}
+
+// SetReplay 开启回放模式，所有会话都从录制文件读取消息
+func (h *Handler) SetReplay(opts *ReplayOptions) {
+	h.replay = opts
+}
+
+func (h *Handler) Config() *config.Config { return h.cfg.Load() }
+
+func (h *Handler) Templates() *TextTemplates { return h.templates.Load() }
+
+// ReloadConfig 热更新配置，校验失败时不会修改正在使用的配置
+// 已经创建的直播间会话会继续使用原来的开放平台客户端，数据库路径需要重启才能生效
+func (h *Handler) ReloadConfig(cfg *config.Config) error {
+	templates, err := NewTextTemplates(cfg.Templates)
+	if err != nil {
+		return fmt.Errorf("NewTextTemplates err: %w", err)
+	}
+	if err := h.TTS.SetConfig(cfg); err != nil {
+		return fmt.Errorf("TTS.SetConfig err: %w", err)
+	}
+
+	old := h.cfg.Load()
+	if cfg.DbPath != old.DbPath {
+		log.Warnf("db_path changed from %s to %s, restart required", old.DbPath, cfg.DbPath)
+	}
+
+	h.cfg.Store(cfg)
+	h.templates.Store(templates)
+	h.liveClient.Store(newLiveClient(cfg.BiliBili))
+	h.LLM.SetConfig(cfg.QianFan)
+	h.sessions.Reload(cfg)
+	return nil
 }
 
 type GiftWithTimer struct {
-	Uname    string
-	GiftName string
-	GiftNum  int32
-	Timer    *time.Timer
+	Gift    GiftData
+	GiftNum int32
+	Timer   *time.Timer
 }
 
 type LiveConfig struct {
@@ -148,509 +186,15 @@ func (h *Handler) WebSocket(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	ctx, cancel := context.WithCancel(c.Request.Context())
-	defer cancel()
-
-	var (
-		startResp *live.AppStartResponse
-		tk        *time.Ticker
-		wcs       *basic.WsClient
-	)
+	var session *Session
+	// 携带管理 Token 的连接才能发送管理请求，每次请求时检查以便配置热更新后生效
+	var adminToken string
 	defer func() {
-		if wcs != nil {
-			wcs.Close()
-		}
-		if tk != nil {
-			tk.Stop()
-		}
-		if startResp != nil {
-			h.liveClient.AppEnd(startResp.GameInfo.GameID)
-		}
-	}()
-
-	isLiving := true
-	livingCfg := LiveConfig{
-		DisableLlm: false,
-	}
-
-	var lastEnterUser *UserData = nil
-	lastEnterUserTimer := time.NewTimer(LastEnterUserDuration)
-	defer lastEnterUserTimer.Stop()
-
-	ttsQueue := tts.NewTTSQueue(h.TTS)
-	defer ttsQueue.Close()
-	ttsCh := ttsQueue.ListenResult()
-	go func() {
-		for r := range ttsCh {
-			if err := r.Err; err != nil {
-				conn.WriteResultError(ResultTypeTTS, CodeInternalError, err.Error())
-				continue
-			}
-			conn.WriteResultOK(ResultTypeTTS, gin.H{
-				"audio_file_path": r.Fname,
-			})
-			lastEnterUserTimer.Reset(LastEnterUserDuration)
-		}
-	}()
-	pushTTS := func(params *tts.NewTaskParams, force bool) {
-		if !isLiving && !force {
-			return
-		}
-		if err := ttsQueue.Push(params); err != nil {
-			conn.WriteResultError(ResultTypeTTS, CodeInternalError, err.Error())
-		}
-	}
-
-	go func() {
-		for range lastEnterUserTimer.C {
-			if lastEnterUser == nil {
-				lastEnterUserTimer.Reset(LastEnterUserDuration)
-				continue
-			}
-			pushTTS(&tts.NewTaskParams{
-				Text: fmt.Sprintf("欢迎%s酱来到直播间", lastEnterUser.Uname),
-			}, false)
-			lastEnterUserTimer.Reset(LastEnterUserDuration)
+		if session != nil {
+			h.sessions.Leave(session, conn)
 		}
 	}()
 
-	historyMsgLru := expirable.NewLRU[string, *ChatMessage](512, nil, MessageExpiration)
-	llmReplyLru := expirable.NewLRU[string, struct{}](LlmReplyLimitCount, nil, LlmReplyLimitDuration)
-	probabilityLlmTriggerRandom := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	isLlmProcessing := false
-	startLlmReply := func(force bool) {
-		if !isLiving || livingCfg.DisableLlm {
-			return
-		}
-
-		var msgs []*ChatMessage
-		userMap := map[string]struct{}{}
-		probabilityLlmTriggerCounter := -1 // 当前尝试触发的用户不算，所以初始值为-1
-		for _, msg := range historyMsgLru.Values() {
-			if time.Since(msg.Timestamp) <= LlmHistoryDuration {
-				msgs = append(msgs, msg)
-			}
-			if time.Since(msg.Timestamp) <= DisableLlmByUserCountDuration {
-				userMap[msg.OpenId] = struct{}{}
-			}
-			if time.Since(msg.Timestamp) <= ProbabilityLlmTriggerDuration {
-				probabilityLlmTriggerCounter++
-			}
-		}
-
-		if !force {
-			llmReplyLruLen := llmReplyLru.Len()
-			if llmReplyLruLen >= LlmReplyLimitCount {
-				log.Infof("disable llm by reply count: %d", llmReplyLruLen)
-				return
-			}
-
-			if len(userMap) >= DisableLlmByUserCount {
-				log.Infof("disable llm by user count: %d", len(userMap))
-				return
-			}
-
-			currentMsg := msgs[len(msgs)-1]
-			if IsRepeatedChar(currentMsg.Message) {
-				log.Infof("disable llm by repeated msg: %s", currentMsg.Message)
-				return
-			}
-
-			var probability float64
-			if probabilityLlmTriggerCounter > ProbabilityLlmTriggerLevel2Count {
-				probability = ProbabilityLlmTriggerLevel3
-			} else if probabilityLlmTriggerCounter > ProbabilityLlmTriggerLevel1Count {
-				probability = ProbabilityLlmTriggerLevel2
-			} else {
-				probability = ProbabilityLlmTriggerLevel1
-			}
-
-			r := probabilityLlmTriggerRandom.Float64()
-			fmt.Printf("r: %.2f, probability: %.2f\n", r, probability)
-			if r <= probability {
-				log.Infof("disable llm by probability: %.2f, counter: %d, compare: %.2f", r, probabilityLlmTriggerCounter, probability)
-				return
-			}
-		}
-
-		isLlmProcessing = true
-		go func(msgs []*ChatMessage) {
-			defer func() {
-				isLlmProcessing = false
-			}()
-
-			llmMsgs := make([]*llm.ChatMessage, len(msgs))
-			for i, msg := range msgs {
-				llmMsgs[i] = &llm.ChatMessage{
-					User:    msg.User,
-					Message: msg.Message,
-				}
-			}
-			llmRes, err := h.LLM.ChatWithLLM(context.Background(), llmMsgs)
-			if err != nil {
-				conn.WriteResultError(ResultTypeLLM, CodeInternalError, err.Error())
-				log.Errorf("ChatWithLLM err: %v", err)
-				return
-			}
-			conn.WriteResultOK(ResultTypeLLM, gin.H{
-				"llm_result": llmRes,
-			})
-			llmReplyLru.Add(uuid.NewV4().String(), struct{}{})
-			pushTTS(&tts.NewTaskParams{
-				Text: llmRes,
-			}, false)
-		}(msgs)
-	}
-
-	giftTimerMap := make(map[string]*GiftWithTimer)
-	var giftTimerMapMutex sync.RWMutex
-
-	init := func(code string) {
-		if startResp != nil {
-			conn.WriteResultError(ResultTypeRoom, http.StatusBadRequest, "connection already init")
-			return
-		}
-
-		log.Infof("init code: %s", code)
-		startResp, err = h.liveClient.AppStart(code)
-		if err != nil {
-			conn.WriteResultError(ResultTypeRoom, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		tk = time.NewTicker(time.Second * 20)
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-tk.C:
-					// 心跳
-					if err := h.liveClient.AppHeartbeat(startResp.GameInfo.GameID); err != nil {
-						log.Errorf("Heartbeat fail, err: %v", err)
-						cancel()
-						conn.Close()
-						return
-					}
-				}
-			}
-		}()
-
-		// close 事件处理
-		onCloseHandle := func(wcs *basic.WsClient, startResp basic.StartResp, closeType int) {
-			// 注册关闭回调
-			log.Infof("WebsocketClient onClose, startResp: %v", startResp)
-
-			// 注意检查关闭类型, 避免无限重连
-			if closeType == basic.CloseActively || closeType == basic.CloseReceivedShutdownMessage || closeType == basic.CloseAuthFailed {
-				log.Infof("WebsocketClient exit")
-				return
-			}
-
-			// 对于可能的情况下重新连接
-			// 注意: 在某些场景下 startResp 会变化, 需要重新获取
-			// 此外, 一但 AppHeartbeat 失败, 会导致 startResp.GameInfo.GameID 变化, 需要重新获取
-			err := wcs.Reconnection(startResp)
-			if err != nil {
-				log.Errorf("Reconnection fail, err: %v", err)
-				conn.WriteResultError(ResultTypeRoom, CodeInternalError, err.Error())
-				cancel()
-				conn.Close()
-				return
-			}
-		}
-
-		// 消息处理 Handle
-		dispatcherHandleMap := basic.DispatcherHandleMap{
-			proto.OperationMessage: func(_ *basic.WsClient, msg *proto.Message) error {
-				// 单条消息raw
-				log.Infof(string(msg.Payload()))
-
-				// 自动解析
-				_, data, err := proto.AutomaticParsingMessageCommand(msg.Payload())
-				if err != nil {
-					log.Errorf("proto.AutomaticParsingMessageCommand err: %v", err)
-					return err
-				}
-
-				// Switch cmd
-				switch d := data.(type) {
-				case *proto.CmdDanmuData:
-					{
-						if _, ok := danmuGiftMap[d.Msg]; ok {
-							break
-						}
-						u := UserData{
-							OpenID:                 d.OpenID,
-							Uname:                  d.Uname,
-							UFace:                  convertImgUrl(d.UFace),
-							FansMedalLevel:         d.FansMedalLevel,
-							FansMedalName:          d.FansMedalName,
-							FansMedalWearingStatus: d.FansMedalWearingStatus,
-							GuardLevel:             d.GuardLevel,
-						}
-						danmuData := &DanmuData{
-							UserData:    u,
-							Msg:         d.Msg,
-							MsgID:       d.MsgID,
-							Timestamp:   d.Timestamp,
-							EmojiImgUrl: d.EmojiImgUrl,
-							DmType:      d.DmType,
-						}
-						conn.WriteResultOK(ResultTypeDanmu, danmuData)
-
-						go h.setUser(u)
-
-						historyMsgLru.Add(d.MsgID, &ChatMessage{
-							OpenId:    danmuData.OpenID,
-							User:      danmuData.Uname,
-							Message:   danmuData.Msg,
-							Timestamp: time.Now(),
-						})
-
-						pitchRate := 0
-						//if !livingCfg.DisableLlm {
-						//	pitchRate = -100
-						//}
-						pushTTS(&tts.NewTaskParams{
-							Text:      fmt.Sprintf("%s说：%s", d.Uname, d.Msg),
-							PitchRate: pitchRate,
-						}, false)
-
-						if isLlmProcessing {
-							break
-						}
-
-						if (danmuData.FansMedalWearingStatus &&
-							danmuData.FansMedalName == FansMedalName &&
-							danmuData.FansMedalLevel >= LlmReplyFansMedalLevel) || // 带10级粉丝牌
-							danmuData.GuardLevel > 0 || // 舰长
-							(danmuData.Uname == "巫女酱子" || danmuData.Uname == "青云-_-z") {
-							startLlmReply(false)
-						}
-
-						break
-					}
-				case *proto.CmdSuperChatData:
-					{
-						u := UserData{
-							OpenID:                 d.OpenID,
-							Uname:                  d.Uname,
-							UFace:                  convertImgUrl(d.Uface),
-							FansMedalLevel:         d.FansMedalLevel,
-							FansMedalName:          d.FansMedalName,
-							FansMedalWearingStatus: d.FansMedalWearingStatus,
-							GuardLevel:             d.GuardLevel,
-						}
-						scData := &SuperChatData{
-							UserData:  u,
-							Msg:       d.Message,
-							MsgID:     d.MsgID,
-							MessageID: d.MessageID,
-							Rmb:       float64(d.Rmb),
-							Timestamp: d.Timestamp,
-							StartTime: d.StartTime,
-							EndTime:   d.EndTime,
-						}
-						conn.WriteResultOK(ResultTypeSuperChat, scData)
-
-						go h.setUser(u)
-
-						historyMsgLru.Add(d.MsgID, &ChatMessage{
-							OpenId:    scData.OpenID,
-							User:      scData.Uname,
-							Message:   scData.Msg,
-							Timestamp: time.Now(),
-						})
-						pushTTS(&tts.NewTaskParams{
-							Text: fmt.Sprintf("谢谢%s酱的醒目留言：%s", d.Uname, d.Message),
-						}, false)
-						startLlmReply(true)
-						break
-					}
-				case *proto.CmdSendGiftData:
-					{
-						u := UserData{
-							OpenID:                 d.OpenID,
-							Uname:                  d.Uname,
-							UFace:                  convertImgUrl(d.Uface),
-							FansMedalLevel:         d.FansMedalLevel,
-							FansMedalName:          d.FansMedalName,
-							FansMedalWearingStatus: d.FansMedalWearingStatus,
-							GuardLevel:             d.GuardLevel,
-						}
-						conn.WriteResultOK(ResultTypeGift, &GiftData{
-							UserData:  u,
-							GiftID:    d.GiftID,
-							GiftName:  d.GiftName,
-							GiftNum:   d.GiftNum,
-							Rmb:       float64(d.Price) / 1000,
-							Paid:      d.Paid,
-							Timestamp: d.Timestamp,
-							MsgID:     d.MsgID,
-							GiftIcon:  d.GiftIcon,
-							ComboGift: d.ComboGift,
-							ComboInfo: &GiftDataComboInfo{
-								ComboBaseNum: d.ComboInfo.ComboBaseNum,
-								ComboCount:   d.ComboInfo.ComboCount,
-								ComboID:      d.ComboInfo.ComboID,
-								ComboTimeout: d.ComboInfo.ComboTimeout,
-							},
-						})
-
-						go h.setUser(u)
-
-						key := fmt.Sprintf("%s-%d", d.OpenID, d.GiftID)
-
-						giftTimerMapMutex.RLock()
-						gt, ok := giftTimerMap[key]
-						giftTimerMapMutex.RUnlock()
-						if ok {
-							atomic.AddInt32(&gt.GiftNum, int32(d.GiftNum))
-							gt.Timer.Reset(GiftComboDuration)
-							break
-						}
-
-						gt = &GiftWithTimer{
-							Uname:    d.Uname,
-							GiftNum:  int32(d.GiftNum),
-							GiftName: d.GiftName,
-							Timer:    time.NewTimer(GiftComboDuration),
-						}
-
-						giftTimerMapMutex.Lock()
-						giftTimerMap[key] = gt
-						giftTimerMapMutex.Unlock()
-						go func(gt *GiftWithTimer) {
-							defer gt.Timer.Stop()
-							<-gt.Timer.C
-
-							giftTimerMapMutex.Lock()
-							delete(giftTimerMap, key)
-							giftTimerMapMutex.Unlock()
-
-							giftNum := atomic.LoadInt32(&gt.GiftNum)
-							pushTTS(&tts.NewTaskParams{
-								Text: fmt.Sprintf("谢谢%s酱赠送的%d个%s 么么哒", gt.Uname, giftNum, gt.GiftName),
-							}, false)
-						}(gt)
-						break
-					}
-				case *proto.CmdGuardData:
-					{
-						u := UserData{
-							OpenID:                 d.UserInfo.OpenID,
-							Uname:                  d.UserInfo.Uname,
-							UFace:                  convertImgUrl(d.UserInfo.Uface),
-							FansMedalLevel:         d.FansMedalLevel,
-							FansMedalName:          d.FansMedalName,
-							FansMedalWearingStatus: d.FansMedalWearingStatus,
-							GuardLevel:             d.GuardLevel,
-						}
-						conn.WriteResultOK(ResultTypeGuard, &GuardData{
-							UserData:   u,
-							GuardLevel: d.GuardLevel,
-							GuardNum:   d.GuardNum,
-							GuardUnit:  d.GuardUnit,
-							Timestamp:  d.Timestamp,
-							MsgID:      d.MsgID,
-						})
-						go h.setUser(u)
-						guardName := getGuardLevelName(d.GuardLevel)
-						pushTTS(&tts.NewTaskParams{
-							Text: fmt.Sprintf("谢谢%s酱赠送的%d个%s%s，么么哒", d.UserInfo.Uname, d.GuardNum, d.GuardUnit, guardName),
-						}, false)
-						break
-					}
-				case *proto.CmdLiveStartData:
-					{
-						pushTTS(&tts.NewTaskParams{
-							Text: "主人开始直播啦，弹幕姬启动！",
-						}, true)
-						isLiving = true
-						break
-					}
-				case *proto.CmdLiveEndData:
-					{
-						pushTTS(&tts.NewTaskParams{
-							Text: "主人直播结束啦，今天辛苦了！",
-						}, true)
-						isLiving = false
-						break
-					}
-				case *proto.CmdLiveRoomEnterData:
-					{
-						u := UserData{
-							OpenID: d.OpenID,
-							Uname:  d.Uname,
-							UFace:  d.Uface,
-						}
-						conn.WriteResultOK(ResultTypeEnterRoom, &RoomEnterData{
-							UserData:  u,
-							Timestamp: d.Timestamp,
-						})
-
-						lastEnterUser = &u
-
-						go func(openId string) {
-							u, err := h.Dao.GetUser(context.Background(), openId)
-							if err != nil {
-								log.Errorf("GetUser open_id: %s err: %v", openId, err)
-								return
-							}
-
-							if u == nil {
-								return
-							}
-
-							if (u.FansMedalWearingStatus && u.FansMedalLevel >= RoomEnterTTSFansMedalLevel) ||
-								u.GuardLevel > 0 {
-
-								name := d.Uname
-								if u.GuardLevel > 0 {
-									guardName := getGuardLevelName(u.GuardLevel)
-									name = guardName + name
-								}
-
-								pushTTS(&tts.NewTaskParams{
-									Text: fmt.Sprintf("欢迎%s酱来到直播间", name),
-								}, false)
-							}
-						}(d.OpenID)
-
-						break
-					}
-				default:
-					{
-						break
-					}
-				}
-
-				return nil
-			},
-		}
-
-		wcs, err = basic.StartWebsocket(
-			startResp,
-			dispatcherHandleMap,
-			onCloseHandle,
-			h.slog,
-		)
-		if err != nil {
-			log.Errorf("basic.StartWebsocket err: %v", err)
-			conn.WriteResultError(ResultTypeRoom, CodeInternalError, err.Error())
-			return
-		}
-
-		log.Infof("room_info: %v", startResp.AnchorInfo)
-		conn.WriteResultOK(ResultTypeRoom, &RoomData{
-			RoomID: startResp.AnchorInfo.RoomID,
-			Uname:  startResp.AnchorInfo.Uname,
-			UFace:  convertImgUrl(startResp.AnchorInfo.UFace),
-		})
-	}
-
 	for {
 		var req WebSocketRequest
 		if err := conn.ReadJSON(&req); err != nil {
@@ -672,7 +216,8 @@ func (h *Handler) WebSocket(c *gin.Context) {
 					conn.WriteResultError(ResultTypeRoom, CodeBadRequest, err.Error())
 					return
 				}
-				if !h.cfg.BiliBili.DisableValidateSign {
+				cfg := h.Config()
+				if !cfg.BiliBili.DisableValidateSign && h.replay == nil {
 					signParams := live.H5SignatureParams{
 						Timestamp: strconv.FormatInt(initData.Timestamp, 10),
 						Code:      initData.Code,
@@ -681,16 +226,31 @@ func (h *Handler) WebSocket(c *gin.Context) {
 
 						CodeSign: initData.CodeSign,
 					}
-					if ok := signParams.ValidateSignature(h.cfg.BiliBili.SecretKey); !ok {
+					if ok := signParams.ValidateSignature(cfg.BiliBili.SecretKey); !ok {
 						conn.WriteResultError(ResultTypeRoom, CodeBadRequest, "invalid signature")
 						return
 					}
 				}
 
-				livingCfg = initData.Config
-				conn.WriteResultOK(ResultTypeConfig, livingCfg)
+				if session != nil {
+					conn.WriteResultError(ResultTypeRoom, http.StatusBadRequest, "connection already init")
+					break
+				}
 
-				init(initData.Code)
+				conn.SetStreamAudio(initData.StreamAudio)
+				conn.SetPlayAudio(initData.PlayAudio)
+				adminToken = initData.AdminToken
+				// 同一个身份码共享会话，只有创建会话的连接的配置会生效
+				session, err = h.sessions.Join(initData.Code, conn, initData.Config)
+				if err != nil {
+					conn.WriteResultError(ResultTypeRoom, http.StatusInternalServerError, err.Error())
+					break
+				}
+				for _, status := range h.TTS.Statuses() {
+					if status.Open {
+						conn.WriteResultOK(ResultTypeTTSStatus, status)
+					}
+				}
 				break
 			}
 		case RequestTypeConfig:
@@ -704,8 +264,65 @@ func (h *Handler) WebSocket(c *gin.Context) {
 					conn.WriteResultError(ResultTypeConfig, CodeBadRequest, err.Error())
 					return
 				}
-				livingCfg = configData
-				conn.WriteResultOK(ResultTypeConfig, livingCfg)
+				if session == nil {
+					conn.WriteResultError(ResultTypeConfig, CodeBadRequest, "connection not init")
+					break
+				}
+				session.SetConfig(configData)
+				break
+			}
+		case RequestTypeMute:
+			{
+				if req.Data == nil {
+					conn.WriteResultError(ResultTypeMute, CodeBadRequest, "data is null")
+					return
+				}
+				var muteData MuteRequestData
+				if err := json.Unmarshal(req.Data, &muteData); err != nil {
+					conn.WriteResultError(ResultTypeMute, CodeBadRequest, err.Error())
+					return
+				}
+				if session == nil {
+					conn.WriteResultError(ResultTypeMute, CodeBadRequest, "connection not init")
+					break
+				}
+				if !h.isAdminToken(adminToken) {
+					conn.WriteResultError(ResultTypeMute, CodeForbidden, "admin token required")
+					break
+				}
+				if muteData.OpenID == "" || muteData.Duration < 0 {
+					conn.WriteResultError(ResultTypeMute, CodeBadRequest, "invalid mute data")
+					break
+				}
+				data, err := h.setMute(context.Background(), &muteData)
+				if err != nil {
+					conn.WriteResultError(ResultTypeMute, CodeInternalError, err.Error())
+					break
+				}
+				session.Broadcast(ResultTypeMute, data)
+				break
+			}
+		case RequestTypeTTSPlayed, RequestTypeTTSSkipped:
+			{
+				if req.Data == nil {
+					conn.WriteResultError(req.Type, CodeBadRequest, "data is null")
+					return
+				}
+				var ackData TTSAckRequestData
+				if err := json.Unmarshal(req.Data, &ackData); err != nil {
+					conn.WriteResultError(req.Type, CodeBadRequest, err.Error())
+					return
+				}
+				if session == nil {
+					conn.WriteResultError(req.Type, CodeBadRequest, "connection not init")
+					break
+				}
+				if ackData.TaskId == "" {
+					conn.WriteResultError(req.Type, CodeBadRequest, "task_id is empty")
+					break
+				}
+				session.ackTTS(conn, ackData.TaskId, req.Type == RequestTypeTTSSkipped)
+				break
 			}
 		case RequestTypeHeartbeat:
 			{
@@ -733,6 +350,12 @@ func (h *Handler) setUser(userData UserData) {
 	}
 }
 
+func (h *Handler) saveEvent(event interface{}) {
+	if err := h.Dao.CreateEvent(context.Background(), event); err != nil {
+		log.Errorf("CreateEvent err: %v", err)
+	}
+}
+
 func getGuardLevelName(guardLevel int) string {
 	guardName, ok := GuardLevelMap[guardLevel]
 	if !ok {
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type ListEventsRequest struct {
+	SessionID string `form:"session_id"`
+	OpenID    string `form:"open_id"`
+	StartTime int64  `form:"start_time"` // 秒级时间戳
+	EndTime   int64  `form:"end_time"`   // 秒级时间戳
+	Page      int    `form:"page"`
+	PageSize  int    `form:"page_size"`
+}
+
+func (req *ListEventsRequest) query() *dao.EventQuery {
+	q := &dao.EventQuery{
+		SessionID: req.SessionID,
+		OpenID:    req.OpenID,
+		Page:      req.Page,
+		PageSize:  req.PageSize,
+	}
+	if req.StartTime > 0 {
+		q.StartTime = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime > 0 {
+		q.EndTime = time.Unix(req.EndTime, 0)
+	}
+	return q
+}
+
+// ListEvents 分页查询历史直播事件，路径参数 type 为 danmu、superchat、gift、guard
+func (h *Handler) ListEvents(c *gin.Context) {
+	var req ListEventsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	q := req.query()
+
+	var (
+		page interface{}
+		err  error
+	)
+	ctx := c.Request.Context()
+	switch c.Param("type") {
+	case dao.EventTypeDanmu:
+		page, err = h.Dao.ListDanmuEvents(ctx, q)
+	case dao.EventTypeSuperChat:
+		page, err = h.Dao.ListSuperChatEvents(ctx, q)
+	case dao.EventTypeGift:
+		page, err = h.Dao.ListGiftEvents(ctx, q)
+	case dao.EventTypeGuard:
+		page, err = h.Dao.ListGuardEvents(ctx, q)
+	default:
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, "invalid event type")
+		return
+	}
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, page)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"blive-vup-layer/config"
+	"blive-vup-layer/dao"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// Leaderboard 一个统计范围内的消费排行和发言排行
+type Leaderboard struct {
+	Window   string                  `json:"window"`
+	Spenders []*dao.LeaderboardEntry `json:"spenders"`
+	Chatters []*dao.LeaderboardEntry `json:"chatters"`
+}
+
+type GetLeaderboardRequest struct {
+	RoomID int    `form:"room_id" binding:"required"`
+	Window string `form:"window"`
+	Limit  int    `form:"limit"`
+}
+
+// buildLeaderboard 统计房间在 window 范围内的排行榜，session 为最近一场直播，没有直播记录时排行榜为空
+func (h *Handler) buildLeaderboard(ctx context.Context, roomId int, window string, limit int) (*Leaderboard, error) {
+	d, err := config.ParseLeaderboardWindow(window)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := &Leaderboard{
+		Window:   window,
+		Spenders: []*dao.LeaderboardEntry{},
+		Chatters: []*dao.LeaderboardEntry{},
+	}
+	q := &dao.LeaderboardQuery{
+		RoomID: roomId,
+		Limit:  limit,
+	}
+	switch window {
+	case config.LeaderboardWindowAll:
+	case config.LeaderboardWindowSession:
+		ls, err := h.Dao.GetLatestLiveSession(ctx, roomId)
+		if err != nil {
+			return nil, err
+		}
+		if ls == nil {
+			return lb, nil
+		}
+		q.StartTime = ls.StartTime
+		if ls.EndTime != nil {
+			// 结束时间包含下播时刻的事件
+			q.EndTime = ls.EndTime.Add(time.Millisecond)
+		}
+	default:
+		q.StartTime = time.Now().Add(-d)
+	}
+
+	if lb.Spenders, err = h.Dao.TopSpenders(ctx, q); err != nil {
+		return nil, fmt.Errorf("TopSpenders err: %w", err)
+	}
+	if lb.Chatters, err = h.Dao.TopChatters(ctx, q); err != nil {
+		return nil, fmt.Errorf("TopChatters err: %w", err)
+	}
+	return lb, nil
+}
+
+// buildLeaderboards 按配置的统计范围生成所有排行榜
+func (h *Handler) buildLeaderboards(ctx context.Context, roomId int) ([]*Leaderboard, error) {
+	cfg := h.Config().Leaderboard
+	boards := make([]*Leaderboard, 0, len(cfg.Windows))
+	for _, window := range cfg.Windows {
+		lb, err := h.buildLeaderboard(ctx, roomId, window, cfg.Limit)
+		if err != nil {
+			return nil, err
+		}
+		boards = append(boards, lb)
+	}
+	return boards, nil
+}
+
+// GetLeaderboard 查询排行榜，window 为空时返回配置的所有统计范围
+func (h *Handler) GetLeaderboard(c *gin.Context) {
+	var req GetLeaderboardRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.Window == "" {
+		boards, err := h.buildLeaderboards(ctx, req.RoomID)
+		if err != nil {
+			BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+		BuildResultOk(c, boards)
+		return
+	}
+
+	if _, err := config.ParseLeaderboardWindow(req.Window); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = h.Config().Leaderboard.Limit
+	}
+	lb, err := h.buildLeaderboard(ctx, req.RoomID, req.Window, limit)
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, lb)
+}
+
+// pushLeaderboard 推送排行榜到所有连接
+func (s *Session) pushLeaderboard() {
+	boards, err := s.h.buildLeaderboards(s.ctx, s.roomData.RoomID)
+	if err != nil {
+		log.Errorf("buildLeaderboards err: %v", err)
+		s.BroadcastError(ResultTypeLeaderboard, CodeInternalError, err.Error())
+		return
+	}
+	s.Broadcast(ResultTypeLeaderboard, boards)
+}
+
+// listenLeaderboard 定时推送排行榜，间隔支持热更新
+func (s *Session) listenLeaderboard() {
+	for {
+		interval := s.h.Config().Leaderboard.PushInterval.Duration()
+		enabled := interval > 0
+		if !enabled {
+			interval = time.Minute
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(interval):
+			if enabled {
+				s.pushLeaderboard()
+			}
+		}
+	}
+}
@@ -8,23 +8,30 @@ import (
 	"github.com/baidubce/bce-qianfan-sdk/go/qianfan"
 	log "github.com/sirupsen/logrus"
 	"strings"
+	"sync/atomic"
 )
 
 type LLM struct {
-	cfg            *config.QianFanConfig
+	cfg            atomic.Pointer[config.QianFanConfig]
 	chatCompletion *qianfan.ChatCompletion
 }
 
 func NewLLM(config *config.QianFanConfig) *LLM {
-	cfg := qianfan.GetConfig()
-	cfg.AK = config.AccessKey
-	cfg.SK = config.SecretKey
-	return &LLM{
-		cfg: config,
+	llm := &LLM{
 		chatCompletion: qianfan.NewChatCompletion(
 			qianfan.WithModel("ERNIE-4.0-Turbo-8K"),
 		),
 	}
+	llm.SetConfig(config)
+	return llm
+}
+
+// SetConfig 替换配置，用于配置热更新
+func (llm *LLM) SetConfig(config *config.QianFanConfig) {
+	cfg := qianfan.GetConfig()
+	cfg.AK = config.AccessKey
+	cfg.SK = config.SecretKey
+	llm.cfg.Store(config)
 }
 
 type ChatMessage struct {
@@ -57,7 +64,7 @@ func (llm *LLM) ChatWithLLM(ctx context.Context, messages []*ChatMessage) (strin
 	resp, err := llm.chatCompletion.Do(
 		ctx,
 		&qianfan.ChatCompletionRequest{
-			System:      llm.cfg.Prompt,
+			System:      llm.cfg.Load().Prompt,
 			Temperature: 0.5,
 			TopP:        0.5,
 			Messages: []qianfan.ChatCompletionMessage{
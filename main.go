@@ -32,6 +32,8 @@ func main() {
 	log.SetOutput(logWriter)
 
 	configFilePath := flag.String("config", "./etc/config-dev.toml", "config file path")
+	replayFilePath := flag.String("replay", "", "replay recorded messages from file instead of connecting to bilibili")
+	replaySpeed := flag.Float64("replay_speed", 1, "replay speed, no delay if <= 0")
 	flag.Parse()
 	cfg, err := config.ParseConfig(*configFilePath)
 	if err != nil {
@@ -39,17 +41,25 @@ func main() {
 		return
 	}
 
-	os.RemoveAll(config.ResultFilePath)
-	if err := os.MkdirAll(config.ResultFilePath, 0755); err != nil {
-		log.Fatalf("os.MkdirAll err: %v", err.Error())
+	h, err := NewHandler(cfg, logWriter)
+	if err != nil {
+		log.Fatalf("NewHandler err: %v", err.Error())
 		return
 	}
+	if *replayFilePath != "" {
+		log.Infof("replay mode, file: %s, speed: %.2f", *replayFilePath, *replaySpeed)
+		h.SetReplay(&ReplayOptions{
+			FilePath: *replayFilePath,
+			Speed:    *replaySpeed,
+		})
+	}
 
-	h, err := NewHandler(cfg, logWriter)
+	watcher, err := config.NewWatcher(*configFilePath, h.ReloadConfig)
 	if err != nil {
-		log.Fatalf("NewHandler err: %v", err.Error())
+		log.Fatalf("config.NewWatcher err: %v", err.Error())
 		return
 	}
+	defer watcher.Close()
 
 	gin.SetMode(gin.ReleaseMode)
 	g := gin.New()
@@ -65,9 +75,30 @@ func main() {
 	assetsRouter.Use(cachecontrol.New(cachecontrol.CacheAssetsForeverPreset))
 
 	g.GET("/healthz", func(c *gin.Context) {
+		if err := h.TTS.Health(); err != nil {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		}
 		c.String(http.StatusOK, "ok")
 	})
 	g.GET("/server/ws", h.WebSocket)
+	g.GET("/server/leaderboard", h.GetLeaderboard)
+
+	adminRouter := g.Group("/server/admin", h.AdminAuth)
+	adminRouter.GET("/roles", h.ListRoles)
+	adminRouter.PUT("/roles", h.SetRole)
+	adminRouter.DELETE("/roles/:open_id", h.DeleteRole)
+	adminRouter.GET("/events/:type", h.ListEvents)
+	adminRouter.GET("/filters", h.ListFilterRules)
+	adminRouter.PUT("/filters", h.SaveFilterRule)
+	adminRouter.DELETE("/filters/:id", h.DeleteFilterRule)
+	adminRouter.GET("/filter_hits", h.ListFilterHits)
+	adminRouter.GET("/mutes", h.ListMutes)
+	adminRouter.PUT("/mutes", h.SetMute)
+	adminRouter.DELETE("/mutes/:open_id", h.DeleteMute)
+	adminRouter.GET("/result_files", h.ResultFilesStats)
+	adminRouter.GET("/tts", h.TTSStats)
+	adminRouter.POST("/tts/skip", h.SkipTTS)
 	//assetsRouter.GET("/server/img", HandleImg)
 	staticRouter.StaticFile("/", "./frontend/dist/index.html")
 
@@ -90,5 +121,6 @@ func main() {
 	signal.Notify(stopCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	<-stopCh
 	server.Close()
+	h.TTS.Close()
 	log.Infof("server shutdown")
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"blive-vup-layer/dao"
+	"context"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+type MuteRequestData struct {
+	OpenID   string `json:"open_id" binding:"required"`
+	Uname    string `json:"uname"`
+	Reason   string `json:"reason"`
+	Duration int64  `json:"duration"` // 禁止时长，单位秒，为0时永久禁止
+	Unmute   bool   `json:"unmute"`   // 解除禁止
+}
+
+type MuteData struct {
+	OpenID   string     `json:"open_id"`
+	Uname    string     `json:"uname"`
+	Muted    bool       `json:"muted"`
+	ExpireAt *time.Time `json:"expire_at"`
+}
+
+func (s *Session) isMuted(openId string) bool {
+	muted, err := s.h.Dao.IsMuted(context.Background(), openId)
+	if err != nil {
+		log.Errorf("IsMuted open_id: %s err: %v", openId, err)
+		return false
+	}
+	return muted
+}
+
+// setMute 禁止或解除禁止用户的TTS
+func (h *Handler) setMute(ctx context.Context, req *MuteRequestData) (*MuteData, error) {
+	if req.Unmute {
+		if err := h.Dao.DeleteMute(ctx, req.OpenID); err != nil {
+			return nil, err
+		}
+		return &MuteData{
+			OpenID: req.OpenID,
+			Uname:  req.Uname,
+		}, nil
+	}
+
+	mute := &dao.UserMute{
+		OpenID: req.OpenID,
+		Uname:  req.Uname,
+		Reason: req.Reason,
+	}
+	if req.Duration > 0 {
+		expireAt := time.Now().Add(time.Duration(req.Duration) * time.Second)
+		mute.ExpireAt = &expireAt
+	}
+	if err := h.Dao.SetMute(ctx, mute); err != nil {
+		return nil, err
+	}
+	return &MuteData{
+		OpenID:   mute.OpenID,
+		Uname:    mute.Uname,
+		Muted:    true,
+		ExpireAt: mute.ExpireAt,
+	}, nil
+}
+
+func (h *Handler) ListMutes(c *gin.Context) {
+	mutes, err := h.Dao.ListMutes(c.Request.Context())
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, mutes)
+}
+
+func (h *Handler) SetMute(c *gin.Context) {
+	var req MuteRequestData
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if req.Duration < 0 {
+		BuildResultError(c, http.StatusBadRequest, CodeBadRequest, "duration must not be negative")
+		return
+	}
+
+	data, err := h.setMute(c.Request.Context(), &req)
+	if err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, data)
+}
+
+func (h *Handler) DeleteMute(c *gin.Context) {
+	if err := h.Dao.DeleteMute(c.Request.Context(), c.Param("open_id")); err != nil {
+		BuildResultError(c, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	BuildResultOk(c, nil)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record 录制的开放平台原始消息，每行一条
+type Record struct {
+	Time    int64           `json:"time"` // 毫秒时间戳
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Recorder 将会话收到的原始消息追加写入 JSONL 文件
+type Recorder struct {
+	file      *os.File
+	fileMutex sync.Mutex
+}
+
+func NewRecorder(dir string, roomID int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	fname := filepath.Join(dir, fmt.Sprintf("%d-%s.jsonl", roomID, time.Now().Format("2006-01-02-15-04-05")))
+	file, err := os.OpenFile(fname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file}, nil
+}
+
+func (r *Recorder) Write(payload []byte) error {
+	if !json.Valid(payload) {
+		return errors.New("invalid payload")
+	}
+	line, err := json.Marshal(&Record{
+		Time:    time.Now().UnixMilli(),
+		Payload: payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+	return r.file.Close()
+}
+
+// ReplayOptions 回放模式配置，会话不再连接B站，而是从录制文件读取消息，回放的事件不写入数据库
+type ReplayOptions struct {
+	FilePath string
+	Speed    float64 // 回放倍速，小于等于0时不等待直接回放
+}
+
+// Replay 按录制时的时间间隔回放消息
+func Replay(ctx context.Context, opts *ReplayOptions, handle func(payload []byte) error) error {
+	file, err := os.Open(opts.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var lastTime int64
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("unmarshal record err: %w", err)
+		}
+
+		if lastTime > 0 && opts.Speed > 0 && r.Time > lastTime {
+			wait := time.Duration(float64(time.Duration(r.Time-lastTime)*time.Millisecond) / opts.Speed)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		lastTime = r.Time
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := handle(r.Payload); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(dir, 123)
+	if err != nil {
+		t.Errorf("NewRecorder err: %v", err)
+		return
+	}
+	payloads := []string{
+		`{"cmd":"LIVE_OPEN_PLATFORM_DM","data":{"msg":"1"}}`,
+		`{"cmd":"LIVE_OPEN_PLATFORM_DM","data":{"msg":"2"}}`,
+	}
+	for _, p := range payloads {
+		assert.NoError(t, r.Write([]byte(p)))
+	}
+	assert.Error(t, r.Write([]byte("invalid")))
+	assert.NoError(t, r.Close())
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Errorf("ReadDir err: %v", err)
+		return
+	}
+	assert.Len(t, files, 1)
+
+	var replayed []string
+	err = Replay(context.Background(), &ReplayOptions{
+		FilePath: filepath.Join(dir, files[0].Name()),
+	}, func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, payloads, replayed)
+}
@@ -14,6 +14,8 @@ type Result struct {
 const (
 	CodeOK            = 0
 	CodeBadRequest    = 400
+	CodeUnauthorized  = 401
+	CodeForbidden     = 403
 	CodeInternalError = 500
 )
 
@@ -0,0 +1,1198 @@
+package main
+
+import (
+	"blive-vup-layer/config"
+	"blive-vup-layer/dao"
+	"blive-vup-layer/llm"
+	"blive-vup-layer/tts"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/vtb-link/bianka/basic"
+	"github.com/vtb-link/bianka/live"
+	"github.com/vtb-link/bianka/proto"
+	"golang.org/x/exp/slices"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionManager 直播间会话注册表，同一个身份码的多个连接共享一个会话
+// 调用B站接口和关闭会话时不持有锁，避免一个房间阻塞其他房间
+type SessionManager struct {
+	h *Handler
+
+	sessions      map[string]*sessionEntry
+	sessionsMutex sync.Mutex
+}
+
+// sessionEntry 正在创建或者已经创建的会话，done 关闭后才可以读取 s 和 err
+type sessionEntry struct {
+	s    *Session
+	err  error
+	done chan struct{}
+}
+
+func (e *sessionEntry) ready() bool {
+	select {
+	case <-e.done:
+		return e.s != nil
+	default:
+		return false
+	}
+}
+
+func NewSessionManager(h *Handler) *SessionManager {
+	return &SessionManager{
+		h:        h,
+		sessions: make(map[string]*sessionEntry),
+	}
+}
+
+// Join 订阅身份码对应的会话，会话不存在时会调用 AppStart 创建，同一个身份码只创建一次
+func (m *SessionManager) Join(code string, conn *WebSocketConn, cfg LiveConfig) (*Session, error) {
+	for {
+		m.sessionsMutex.Lock()
+		e, ok := m.sessions[code]
+		if !ok {
+			e = &sessionEntry{done: make(chan struct{})}
+			m.sessions[code] = e
+		}
+		m.sessionsMutex.Unlock()
+
+		if !ok {
+			m.create(e, code, cfg)
+		}
+		<-e.done
+		if e.err != nil {
+			return nil, e.err
+		}
+
+		// 等待期间最后一个连接离开、会话已经关闭时重新创建
+		m.sessionsMutex.Lock()
+		if m.sessions[code] != e {
+			m.sessionsMutex.Unlock()
+			continue
+		}
+		e.s.subscribe(conn)
+		m.sessionsMutex.Unlock()
+
+		e.s.sendInitState(conn)
+		return e.s, nil
+	}
+}
+
+func (m *SessionManager) create(e *sessionEntry, code string, cfg LiveConfig) {
+	s := newSession(m, code, cfg)
+	if err := s.start(); err != nil {
+		m.sessionsMutex.Lock()
+		if m.sessions[code] == e {
+			delete(m.sessions, code)
+		}
+		m.sessionsMutex.Unlock()
+		s.Close()
+		e.err = err
+		close(e.done)
+		return
+	}
+	e.s = s
+	close(e.done)
+}
+
+// remove 从注册表移除会话，需要持有 sessionsMutex
+func (m *SessionManager) remove(s *Session) {
+	if e, ok := m.sessions[s.code]; ok && e.s == s {
+		delete(m.sessions, s.code)
+	}
+}
+
+// Leave 取消订阅，最后一个连接离开时关闭会话
+func (m *SessionManager) Leave(s *Session, conn *WebSocketConn) {
+	m.sessionsMutex.Lock()
+	if s.unsubscribe(conn) > 0 {
+		m.sessionsMutex.Unlock()
+		return
+	}
+	m.remove(s)
+	m.sessionsMutex.Unlock()
+	s.Close()
+}
+
+func (m *SessionManager) all() []*Session {
+	m.sessionsMutex.Lock()
+	defer m.sessionsMutex.Unlock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, e := range m.sessions {
+		if e.ready() {
+			sessions = append(sessions, e.s)
+		}
+	}
+	return sessions
+}
+
+// Reload 配置热更新后通知所有会话
+func (m *SessionManager) Reload(cfg *config.Config) {
+	for _, s := range m.all() {
+		s.reload(cfg)
+	}
+}
+
+// Broadcast 发送给所有会话的所有连接
+func (m *SessionManager) Broadcast(resultType string, data interface{}) {
+	for _, s := range m.all() {
+		s.Broadcast(resultType, data)
+	}
+}
+
+// close 会话异常结束时从注册表移除并关闭
+func (m *SessionManager) close(s *Session) {
+	m.sessionsMutex.Lock()
+	m.remove(s)
+	m.sessionsMutex.Unlock()
+	s.Close()
+}
+
+type Session struct {
+	id   string
+	m    *SessionManager
+	h    *Handler
+	code string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+
+	liveClient *live.Client
+	startResp  *live.AppStartResponse
+	tk         *time.Ticker
+	wcs        *basic.WsClient
+	roomData   *RoomData
+	profile    atomic.Pointer[config.ProfileConfig]
+	recorder   *Recorder
+
+	subscribed     chan struct{}
+	subscribedOnce sync.Once
+
+	subs      map[*WebSocketConn]struct{}
+	player    *WebSocketConn // 播放TTS音频的连接，只有这个连接的播放确认有效
+	subsMutex sync.RWMutex
+
+	isLiving  atomic.Bool
+	livingCfg atomic.Pointer[LiveConfig]
+
+	lastEnterUser         atomic.Pointer[UserData]
+	lastEnterUserDuration time.Duration
+	ttsPlayed             chan struct{} // 播放TTS后推迟欢迎最后一个进入直播间的用户
+
+	ttsQueue *tts.TTSQueue
+	events   *eventWriter
+
+	historyMsgLru               *expirable.LRU[string, *ChatMessage]
+	llmReplyLru                 atomic.Pointer[expirable.LRU[string, struct{}]]
+	probabilityLlmTriggerRandom *rand.Rand
+	isLlmProcessing             atomic.Bool
+
+	giftTimerMap      map[string]*GiftWithTimer
+	giftTimerMapMutex sync.RWMutex
+}
+
+func newSession(m *SessionManager, code string, cfg LiveConfig) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{
+		id:   uuid.NewV4().String(),
+		m:    m,
+		h:    m.h,
+		code: code,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		subs:       make(map[*WebSocketConn]struct{}),
+		subscribed: make(chan struct{}),
+
+		lastEnterUserDuration: LastEnterUserDuration,
+		ttsPlayed:             make(chan struct{}, 1),
+
+		ttsQueue: tts.NewTTSQueue(m.h.TTS, m.h.Config().TTSQueue),
+		events:   newEventWriter(),
+
+		historyMsgLru:               expirable.NewLRU[string, *ChatMessage](512, nil, MessageExpiration),
+		probabilityLlmTriggerRandom: rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		giftTimerMap: make(map[string]*GiftWithTimer),
+	}
+	s.isLiving.Store(true)
+	s.livingCfg.Store(&cfg)
+	return s
+}
+
+func (s *Session) subscribe(conn *WebSocketConn) {
+	s.subsMutex.Lock()
+	s.subs[conn] = struct{}{}
+	if s.player == nil && conn.PlayAudio() {
+		s.player = conn
+	}
+	s.subsMutex.Unlock()
+	s.subscribedOnce.Do(func() {
+		close(s.subscribed)
+	})
+}
+
+// sendInitState 新订阅的连接同步当前配置和房间信息
+func (s *Session) sendInitState(conn *WebSocketConn) {
+	conn.WriteResultOK(ResultTypeConfig, s.liveConfig())
+	conn.WriteResultOK(ResultTypeRoom, s.roomData)
+}
+
+func (s *Session) unsubscribe(conn *WebSocketConn) int {
+	s.subsMutex.Lock()
+	defer s.subsMutex.Unlock()
+
+	delete(s.subs, conn)
+	if s.player == conn {
+		// 播放音频的连接断开后由其他请求播放的连接接替
+		s.player = nil
+		for c := range s.subs {
+			if c.PlayAudio() {
+				s.player = c
+				break
+			}
+		}
+	}
+	return len(s.subs)
+}
+
+// audioConn 返回播放TTS音频的连接，没有时返回 nil
+func (s *Session) audioConn() *WebSocketConn {
+	s.subsMutex.RLock()
+	defer s.subsMutex.RUnlock()
+	return s.player
+}
+
+func (s *Session) conns() []*WebSocketConn {
+	s.subsMutex.RLock()
+	defer s.subsMutex.RUnlock()
+
+	conns := make([]*WebSocketConn, 0, len(s.subs))
+	for conn := range s.subs {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Broadcast 将结果推送给所有订阅的连接
+func (s *Session) Broadcast(resultType string, data interface{}) {
+	for _, conn := range s.conns() {
+		conn.WriteResultOK(resultType, data)
+	}
+}
+
+func (s *Session) BroadcastError(resultType string, code int, msg string) {
+	for _, conn := range s.conns() {
+		conn.WriteResultError(resultType, code, msg)
+	}
+}
+
+func (s *Session) liveConfig() LiveConfig { return *s.livingCfg.Load() }
+
+func (s *Session) SetConfig(cfg LiveConfig) {
+	s.livingCfg.Store(&cfg)
+	s.Broadcast(ResultTypeConfig, cfg)
+}
+
+// Close 结束会话，关闭长连并调用 AppEnd
+func (s *Session) Close() {
+	s.once.Do(func() {
+		s.cancel()
+		if s.wcs != nil {
+			// bianka 先发送关闭帧再停止读取协程，服务端立即断开时读取协程会同时调用 CloseWithType，
+			// 这是 bianka 内部的竞争，onClose 中已经根据 ctx 跳过重连
+			s.wcs.Close()
+		}
+		if s.recorder != nil {
+			s.recorder.Close()
+		}
+		if s.tk != nil {
+			s.tk.Stop()
+		}
+		if s.startResp != nil {
+			s.liveClient.AppEnd(s.startResp.GameInfo.GameID)
+		}
+		s.ttsQueue.Close()
+		s.events.Close()
+
+		for _, conn := range s.conns() {
+			conn.Close()
+		}
+	})
+}
+
+func (s *Session) start() error {
+	if s.h.replay != nil {
+		return s.startReplay(s.h.replay)
+	}
+
+	log.Infof("init code: %s", s.code)
+	s.liveClient = s.h.liveClient.Load()
+	startResp, err := s.liveClient.AppStart(s.code)
+	if err != nil {
+		return err
+	}
+	s.startResp = startResp
+	s.setProfile(s.h.Config().Profile(startResp.AnchorInfo.RoomID))
+
+	log.Infof("room_info: %v", startResp.AnchorInfo)
+	s.roomData = &RoomData{
+		SessionID: s.id,
+		RoomID:    startResp.AnchorInfo.RoomID,
+		Uname:     startResp.AnchorInfo.Uname,
+		UFace:     convertImgUrl(startResp.AnchorInfo.UFace),
+	}
+
+	if recordPath := s.h.Config().RecordPath; recordPath != "" {
+		s.recorder, err = NewRecorder(recordPath, startResp.AnchorInfo.RoomID)
+		if err != nil {
+			log.Errorf("NewRecorder err: %v", err)
+		}
+	}
+
+	go s.listenTTS()
+	go s.listenLastEnterUser()
+	go s.listenLeaderboard()
+
+	s.tk = time.NewTicker(time.Second * 20)
+	go func() {
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.tk.C:
+				// 心跳
+				if err := s.liveClient.AppHeartbeat(s.startResp.GameInfo.GameID); err != nil {
+					log.Errorf("Heartbeat fail, err: %v", err)
+					s.m.close(s)
+					return
+				}
+			}
+		}
+	}()
+
+	// close 事件处理
+	onCloseHandle := func(wcs *basic.WsClient, startResp basic.StartResp, closeType int) {
+		// 注册关闭回调
+		log.Infof("WebsocketClient onClose, startResp: %v", startResp)
+
+		// 注意检查关闭类型, 避免无限重连
+		// 会话关闭时 bianka 的读取协程可能先于 Close 发现连接断开，这时也不能重连
+		if s.ctx.Err() != nil || closeType == basic.CloseActively || closeType == basic.CloseReceivedShutdownMessage || closeType == basic.CloseAuthFailed {
+			log.Infof("WebsocketClient exit")
+			return
+		}
+
+		// 对于可能的情况下重新连接
+		// 注意: 在某些场景下 startResp 会变化, 需要重新获取
+		// 此外, 一但 AppHeartbeat 失败, 会导致 startResp.GameInfo.GameID 变化, 需要重新获取
+		err := wcs.Reconnection(startResp)
+		if err != nil {
+			log.Errorf("Reconnection fail, err: %v", err)
+			s.BroadcastError(ResultTypeRoom, CodeInternalError, err.Error())
+			go s.m.close(s)
+			return
+		}
+	}
+
+	// 消息处理 Handle
+	dispatcherHandleMap := basic.DispatcherHandleMap{
+		proto.OperationMessage: func(_ *basic.WsClient, msg *proto.Message) error {
+			if s.recorder != nil {
+				if err := s.recorder.Write(msg.Payload()); err != nil {
+					log.Errorf("Recorder.Write err: %v", err)
+				}
+			}
+			return s.handleMessage(msg.Payload())
+		},
+	}
+
+	s.wcs, err = basic.StartWebsocket(
+		startResp,
+		dispatcherHandleMap,
+		onCloseHandle,
+		s.h.slog,
+	)
+	if err != nil {
+		log.Errorf("basic.StartWebsocket err: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// startReplay 回放模式，不连接B站，从录制文件读取消息
+func (s *Session) startReplay(opts *ReplayOptions) error {
+	log.Infof("replay code: %s, file: %s", s.code, opts.FilePath)
+	if _, err := os.Stat(opts.FilePath); err != nil {
+		return err
+	}
+	s.setProfile(s.h.Config().Profile(0))
+	s.roomData = &RoomData{
+		SessionID: s.id,
+		Uname:     filepath.Base(opts.FilePath),
+	}
+
+	go s.listenTTS()
+	go s.listenLastEnterUser()
+	go s.listenLeaderboard()
+	go func() {
+		// 等待第一个连接订阅后再开始回放，避免丢失消息
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.subscribed:
+		}
+
+		err := Replay(s.ctx, opts, s.handleMessage)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Errorf("Replay err: %v", err)
+			s.BroadcastError(ResultTypeRoom, CodeInternalError, err.Error())
+			return
+		}
+		log.Infof("replay done, file: %s", opts.FilePath)
+	}()
+	return nil
+}
+
+func (s *Session) Profile() *config.ProfileConfig { return s.profile.Load() }
+
+func (s *Session) setProfile(p *config.ProfileConfig) {
+	old := s.profile.Swap(p)
+	if old == nil || old.LlmReplyLimitCount != p.LlmReplyLimitCount || old.LlmReplyLimitDuration != p.LlmReplyLimitDuration {
+		s.llmReplyLru.Store(expirable.NewLRU[string, struct{}](p.LlmReplyLimitCount, nil, p.LlmReplyLimitDuration.Duration()))
+	}
+}
+
+func (s *Session) reload(cfg *config.Config) {
+	if s.roomData == nil {
+		return
+	}
+	s.setProfile(cfg.Profile(s.roomData.RoomID))
+	s.ttsQueue.SetConfig(cfg.TTSQueue)
+	s.Broadcast(ResultTypeConfig, s.liveConfig())
+}
+
+func (s *Session) listenTTS() {
+	s.ttsQueue.SetStreamHandler(&ttsStream{s: s})
+	for r := range s.ttsQueue.ListenResult() {
+		if r.DropReason != "" {
+			log.Infof("tts dropped, reason: %s, text: %s", r.DropReason, r.Text)
+			s.Broadcast(ResultTypeTTSDrop, gin.H{
+				"text":     r.Text,
+				"priority": r.Priority,
+				"reason":   r.DropReason,
+			})
+			continue
+		}
+		if err := r.Err; err != nil {
+			s.BroadcastError(ResultTypeTTS, CodeInternalError, err.Error())
+			continue
+		}
+		// 只推送给播放音频的连接，开启 stream_audio 时已经通过 ResultTypeTTSEnd 收到结果
+		if conn := s.audioConn(); conn != nil && !conn.StreamAudio() {
+			conn.WriteResultOK(ResultTypeTTS, gin.H{
+				"task_id":         r.TaskId,
+				"audio_file_path": r.Fname,
+				"duration":        r.Duration.Milliseconds(),
+				"subtitles":       r.Subtitles,
+			})
+		}
+		select {
+		case s.ttsPlayed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ackTTS 前端播放完成或者跳过音频，跳过时通知其他连接停止播放
+// 只接受播放音频的连接的确认，其他连接的确认和过期的 taskId 会被忽略
+func (s *Session) ackTTS(conn *WebSocketConn, taskId string, skipped bool) {
+	if conn != s.audioConn() {
+		log.Warnf("ignore tts ack from connection not playing audio, task_id: %s", taskId)
+		return
+	}
+	if !s.ttsQueue.Ack(taskId) {
+		return
+	}
+	if skipped {
+		log.Infof("tts skipped by client, task_id: %s", taskId)
+		s.Broadcast(ResultTypeTTSSkip, gin.H{"task_id": taskId})
+	}
+}
+
+// skipTTS 跳过正在播放的音频，没有正在播放的音频时返回 false
+func (s *Session) skipTTS() bool {
+	taskId, ok := s.ttsQueue.Skip()
+	if !ok {
+		return false
+	}
+	log.Infof("tts skipped, task_id: %s", taskId)
+	s.Broadcast(ResultTypeTTSSkip, gin.H{"task_id": taskId})
+	return true
+}
+
+// listenLastEnterUser 一段时间没有播放TTS时欢迎最后一个进入直播间的用户，定时器只在这个协程中使用
+func (s *Session) listenLastEnterUser() {
+	timer := time.NewTimer(s.lastEnterUserDuration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.ttsPlayed:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(s.lastEnterUserDuration)
+		case <-timer.C:
+			// 同一个用户只欢迎一次
+			if u := s.lastEnterUser.Swap(nil); u != nil {
+				if text, ok := s.renderTTS(TemplateRoomEnter, &RoomEnterData{
+					UserData: *u,
+				}); ok {
+					s.pushTTS(&tts.NewTaskParams{
+						Text:     text,
+						Uname:    u.Uname,
+						Event:    config.VoiceEventEnter,
+						Priority: tts.PriorityWelcome,
+					}, false)
+				}
+			}
+			timer.Reset(s.lastEnterUserDuration)
+		}
+	}
+}
+
+// eventWriter 在一个协程中按顺序写入数据库，Flush 等待之前提交的写入完成
+type eventWriter struct {
+	ch     chan func()
+	done   chan struct{}
+	closed bool
+	mutex  sync.Mutex
+}
+
+func newEventWriter() *eventWriter {
+	w := &eventWriter{
+		ch:   make(chan func(), 256),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for f := range w.ch {
+			f()
+		}
+	}()
+	return w
+}
+
+// Write 提交写入，关闭后返回 false
+func (w *eventWriter) Write(f func()) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return false
+	}
+	w.ch <- f
+	return true
+}
+
+func (w *eventWriter) Flush() {
+	done := make(chan struct{})
+	if w.Write(func() { close(done) }) {
+		<-done
+	}
+}
+
+// Close 等待已经提交的写入完成
+func (w *eventWriter) Close() {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mutex.Unlock()
+	<-w.done
+}
+
+// persist 按顺序写入数据库，回放模式不写入
+func (s *Session) persist(f func()) {
+	if s.h.replay != nil {
+		return
+	}
+	s.events.Write(f)
+}
+
+// saveEvent 保存用户信息和事件
+func (s *Session) saveEvent(u UserData, event interface{}) {
+	s.persist(func() {
+		s.h.setUser(u)
+		s.h.saveEvent(event)
+	})
+}
+
+func (s *Session) newEvent(u UserData, msgId string, timestamp int) dao.Event {
+	t := time.Now()
+	if timestamp > 0 {
+		t = time.Unix(int64(timestamp), 0)
+	}
+	return dao.Event{
+		SessionID: s.id,
+		RoomID:    s.roomData.RoomID,
+		OpenID:    u.OpenID,
+		Uname:     u.Uname,
+		MsgID:     msgId,
+		Time:      t,
+	}
+}
+
+func (s *Session) startLiveSession(startTime time.Time) {
+	if s.h.replay != nil {
+		return
+	}
+	ctx := context.Background()
+	ls, err := s.h.Dao.GetOpenLiveSession(ctx, s.roomData.RoomID)
+	if err != nil {
+		log.Errorf("GetOpenLiveSession err: %v", err)
+		return
+	}
+	if ls != nil {
+		log.Warnf("live session %d not ended, end it before starting a new one", ls.ID)
+		if err := s.h.Dao.EndLiveSession(ctx, ls, startTime); err != nil {
+			log.Errorf("EndLiveSession err: %v", err)
+		}
+	}
+	if _, err := s.h.Dao.StartLiveSession(ctx, s.roomData.RoomID, startTime); err != nil {
+		log.Errorf("StartLiveSession err: %v", err)
+	}
+}
+
+// endLiveSession 结束直播，推送本场直播的统计并播报总结
+func (s *Session) endLiveSession(endTime time.Time) {
+	if s.h.replay != nil {
+		return
+	}
+	// 等待之前的事件写入完成，否则统计会漏掉最后的事件
+	s.events.Flush()
+	ctx := context.Background()
+	ls, err := s.h.Dao.GetOpenLiveSession(ctx, s.roomData.RoomID)
+	if err != nil {
+		log.Errorf("GetOpenLiveSession err: %v", err)
+		return
+	}
+	if ls == nil {
+		log.Warnf("no open live session for room %d", s.roomData.RoomID)
+		return
+	}
+	if err := s.h.Dao.EndLiveSession(ctx, ls, endTime); err != nil {
+		log.Errorf("EndLiveSession err: %v", err)
+		s.BroadcastError(ResultTypeLiveSummary, CodeInternalError, err.Error())
+		return
+	}
+	s.Broadcast(ResultTypeLiveSummary, ls)
+	s.pushLeaderboard()
+
+	if !s.h.Templates().Has(TemplateLiveSummary) {
+		return
+	}
+	if text, ok := s.renderTTS(TemplateLiveSummary, ls); ok {
+		s.pushTTS(&tts.NewTaskParams{
+			Text:     text,
+			Event:    config.VoiceEventSystem,
+			Priority: tts.PrioritySuperChat,
+		}, true)
+	}
+}
+
+func (s *Session) getRole(openId string) string {
+	role, err := s.h.Dao.GetRole(context.Background(), openId)
+	if err != nil {
+		log.Errorf("GetRole open_id: %s err: %v", openId, err)
+		return ""
+	}
+	return role
+}
+
+// renderTTS 使用配置的模板生成TTS文本
+func (s *Session) renderTTS(name string, data interface{}) (string, bool) {
+	text, err := s.h.Templates().Render(name, data)
+	if err != nil {
+		log.Errorf("Render template err: %v", err)
+		s.BroadcastError(ResultTypeTTS, CodeInternalError, err.Error())
+		return "", false
+	}
+	return text, true
+}
+
+func (s *Session) pushTTS(params *tts.NewTaskParams, force bool) {
+	if !s.isLiving.Load() && !force {
+		return
+	}
+	if err := s.ttsQueue.Push(params); err != nil {
+		s.BroadcastError(ResultTypeTTS, CodeInternalError, err.Error())
+	}
+}
+
+func (s *Session) startLlmReply(force bool) {
+	if !s.isLiving.Load() || s.liveConfig().DisableLlm {
+		return
+	}
+
+	p := s.Profile()
+	var msgs []*ChatMessage
+	userMap := map[string]struct{}{}
+	probabilityLlmTriggerCounter := -1 // 当前尝试触发的用户不算，所以初始值为-1
+	for _, msg := range s.historyMsgLru.Values() {
+		if time.Since(msg.Timestamp) <= LlmHistoryDuration {
+			msgs = append(msgs, msg)
+		}
+		if time.Since(msg.Timestamp) <= p.DisableLlmByUserCountDuration.Duration() {
+			userMap[msg.OpenId] = struct{}{}
+		}
+		if time.Since(msg.Timestamp) <= p.ProbabilityLlmTriggerDuration.Duration() {
+			probabilityLlmTriggerCounter++
+		}
+	}
+
+	if !force {
+		llmReplyLruLen := s.llmReplyLru.Load().Len()
+		if llmReplyLruLen >= p.LlmReplyLimitCount {
+			log.Infof("disable llm by reply count: %d", llmReplyLruLen)
+			return
+		}
+
+		if len(userMap) >= p.DisableLlmByUserCount {
+			log.Infof("disable llm by user count: %d", len(userMap))
+			return
+		}
+
+		currentMsg := msgs[len(msgs)-1]
+		if IsRepeatedChar(currentMsg.Message) {
+			log.Infof("disable llm by repeated msg: %s", currentMsg.Message)
+			return
+		}
+
+		var probability float64
+		if probabilityLlmTriggerCounter > p.ProbabilityLlmTriggerLevel2Count {
+			probability = p.ProbabilityLlmTriggerLevel3
+		} else if probabilityLlmTriggerCounter > p.ProbabilityLlmTriggerLevel1Count {
+			probability = p.ProbabilityLlmTriggerLevel2
+		} else {
+			probability = p.ProbabilityLlmTriggerLevel1
+		}
+
+		r := s.probabilityLlmTriggerRandom.Float64()
+		log.Debugf("llm trigger random: %.2f, probability: %.2f", r, probability)
+		if r <= probability {
+			log.Infof("disable llm by probability: %.2f, counter: %d, compare: %.2f", r, probabilityLlmTriggerCounter, probability)
+			return
+		}
+	}
+
+	s.isLlmProcessing.Store(true)
+	go func(msgs []*ChatMessage) {
+		defer func() {
+			s.isLlmProcessing.Store(false)
+		}()
+
+		llmMsgs := make([]*llm.ChatMessage, len(msgs))
+		for i, msg := range msgs {
+			llmMsgs[i] = &llm.ChatMessage{
+				User:    msg.User,
+				Message: msg.Message,
+			}
+		}
+		llmRes, err := s.h.LLM.ChatWithLLM(context.Background(), llmMsgs)
+		if err != nil {
+			s.BroadcastError(ResultTypeLLM, CodeInternalError, err.Error())
+			log.Errorf("ChatWithLLM err: %v", err)
+			return
+		}
+		s.Broadcast(ResultTypeLLM, gin.H{
+			"llm_result": llmRes,
+		})
+		s.llmReplyLru.Load().Add(uuid.NewV4().String(), struct{}{})
+		s.pushTTS(&tts.NewTaskParams{
+			Text:     llmRes,
+			Event:    config.VoiceEventLLM,
+			Priority: tts.PriorityLLM,
+		}, false)
+	}(msgs)
+}
+
+func (s *Session) handleMessage(payload []byte) error {
+	// 单条消息raw
+	log.Infof(string(payload))
+
+	// 自动解析
+	_, data, err := proto.AutomaticParsingMessageCommand(payload)
+	if err != nil {
+		log.Errorf("proto.AutomaticParsingMessageCommand err: %v", err)
+		return err
+	}
+
+	// Switch cmd
+	switch d := data.(type) {
+	case *proto.CmdDanmuData:
+		{
+			if _, ok := danmuGiftMap[d.Msg]; ok {
+				break
+			}
+			role := s.getRole(d.OpenID)
+			u := UserData{
+				OpenID:                 d.OpenID,
+				Uname:                  d.Uname,
+				UFace:                  convertImgUrl(d.UFace),
+				FansMedalLevel:         d.FansMedalLevel,
+				FansMedalName:          d.FansMedalName,
+				FansMedalWearingStatus: d.FansMedalWearingStatus,
+				GuardLevel:             d.GuardLevel,
+			}
+			danmuData := &DanmuData{
+				UserData:    u,
+				Msg:         d.Msg,
+				MsgID:       d.MsgID,
+				Timestamp:   d.Timestamp,
+				EmojiImgUrl: d.EmojiImgUrl,
+				DmType:      d.DmType,
+			}
+			// 指令弹幕同样保存，保证历史记录完整
+			s.saveEvent(u, &dao.DanmuEvent{
+				Event:       s.newEvent(u, d.MsgID, d.Timestamp),
+				Msg:         d.Msg,
+				EmojiImgUrl: d.EmojiImgUrl,
+				DmType:      d.DmType,
+			})
+			if canRunCommand(role) && strings.HasPrefix(d.Msg, CommandPrefix) {
+				s.handleCommand(u, role, d.Msg)
+				break
+			}
+
+			fr := s.applyFilter(&danmuData.UserData, d.MsgID, &danmuData.Msg)
+			if fr.Drop {
+				break
+			}
+			s.Broadcast(ResultTypeDanmu, danmuData)
+
+			if !fr.SkipLLM {
+				s.historyMsgLru.Add(d.MsgID, &ChatMessage{
+					OpenId:    danmuData.OpenID,
+					User:      danmuData.Uname,
+					Message:   danmuData.Msg,
+					Timestamp: time.Now(),
+				})
+			}
+
+			if !fr.SkipTTS {
+				if text, ok := s.renderTTS(TemplateDanmu, danmuData); ok {
+					s.pushTTS(&tts.NewTaskParams{
+						Text:     text,
+						Uname:    danmuData.Uname,
+						Event:    config.VoiceEventDanmu,
+						Priority: tts.PriorityDanmu,
+					}, false)
+				}
+			}
+
+			if s.isLlmProcessing.Load() || fr.SkipLLM {
+				break
+			}
+
+			if (danmuData.FansMedalWearingStatus &&
+				danmuData.FansMedalName == s.Profile().FansMedalName &&
+				danmuData.FansMedalLevel >= s.Profile().LlmReplyFansMedalLevel) || // 带指定等级粉丝牌
+				danmuData.GuardLevel > 0 || // 舰长
+				role != "" || // 主播、房管、VIP
+				slices.Contains(s.Profile().LlmReplyUnames, danmuData.Uname) {
+				s.startLlmReply(false)
+			}
+
+			break
+		}
+	case *proto.CmdSuperChatData:
+		{
+			u := UserData{
+				OpenID:                 d.OpenID,
+				Uname:                  d.Uname,
+				UFace:                  convertImgUrl(d.Uface),
+				FansMedalLevel:         d.FansMedalLevel,
+				FansMedalName:          d.FansMedalName,
+				FansMedalWearingStatus: d.FansMedalWearingStatus,
+				GuardLevel:             d.GuardLevel,
+			}
+			scData := &SuperChatData{
+				UserData:  u,
+				Msg:       d.Message,
+				MsgID:     d.MsgID,
+				MessageID: d.MessageID,
+				Rmb:       float64(d.Rmb),
+				Timestamp: d.Timestamp,
+				StartTime: d.StartTime,
+				EndTime:   d.EndTime,
+			}
+			fr := s.applyFilter(&scData.UserData, d.MsgID, &scData.Msg)
+			if !fr.Drop {
+				s.Broadcast(ResultTypeSuperChat, scData)
+			}
+
+			s.saveEvent(u, &dao.SuperChatEvent{
+				Event:     s.newEvent(u, d.MsgID, d.Timestamp),
+				Msg:       d.Message,
+				MessageID: scData.MessageID,
+				Rmb:       scData.Rmb,
+			})
+			if fr.Drop {
+				break
+			}
+
+			if !fr.SkipLLM {
+				s.historyMsgLru.Add(d.MsgID, &ChatMessage{
+					OpenId:    scData.OpenID,
+					User:      scData.Uname,
+					Message:   scData.Msg,
+					Timestamp: time.Now(),
+				})
+			}
+			if !fr.SkipTTS {
+				if text, ok := s.renderTTS(TemplateSuperChat, scData); ok {
+					s.pushTTS(&tts.NewTaskParams{
+						Text:     text,
+						Uname:    scData.Uname,
+						Event:    config.VoiceEventDanmu,
+						Priority: tts.PrioritySuperChat,
+					}, false)
+				}
+			}
+			if !fr.SkipLLM {
+				s.startLlmReply(true)
+			}
+			break
+		}
+	case *proto.CmdSendGiftData:
+		{
+			u := UserData{
+				OpenID:                 d.OpenID,
+				Uname:                  d.Uname,
+				UFace:                  convertImgUrl(d.Uface),
+				FansMedalLevel:         d.FansMedalLevel,
+				FansMedalName:          d.FansMedalName,
+				FansMedalWearingStatus: d.FansMedalWearingStatus,
+				GuardLevel:             d.GuardLevel,
+			}
+			giftData := &GiftData{
+				UserData:  u,
+				GiftID:    d.GiftID,
+				GiftName:  d.GiftName,
+				GiftNum:   d.GiftNum,
+				Rmb:       float64(d.Price) / 1000,
+				Paid:      d.Paid,
+				Timestamp: d.Timestamp,
+				MsgID:     d.MsgID,
+				GiftIcon:  d.GiftIcon,
+				ComboGift: d.ComboGift,
+				ComboInfo: &GiftDataComboInfo{
+					ComboBaseNum: d.ComboInfo.ComboBaseNum,
+					ComboCount:   d.ComboInfo.ComboCount,
+					ComboID:      d.ComboInfo.ComboID,
+					ComboTimeout: d.ComboInfo.ComboTimeout,
+				},
+			}
+			fr := s.applyFilter(&giftData.UserData, d.MsgID, nil)
+			if !fr.Drop {
+				s.Broadcast(ResultTypeGift, giftData)
+			}
+
+			s.saveEvent(u, &dao.GiftEvent{
+				Event:    s.newEvent(u, d.MsgID, d.Timestamp),
+				GiftID:   giftData.GiftID,
+				GiftName: giftData.GiftName,
+				GiftNum:  giftData.GiftNum,
+				Rmb:      giftData.Rmb,
+				Paid:     giftData.Paid,
+			})
+			if fr.Drop || fr.SkipTTS {
+				break
+			}
+
+			key := fmt.Sprintf("%s-%d", d.OpenID, d.GiftID)
+
+			s.giftTimerMapMutex.RLock()
+			gt, ok := s.giftTimerMap[key]
+			s.giftTimerMapMutex.RUnlock()
+			if ok {
+				atomic.AddInt32(&gt.GiftNum, int32(d.GiftNum))
+				gt.Timer.Reset(s.Profile().GiftComboDuration.Duration())
+				break
+			}
+
+			gt = &GiftWithTimer{
+				Gift:    *giftData,
+				GiftNum: int32(d.GiftNum),
+				Timer:   time.NewTimer(s.Profile().GiftComboDuration.Duration()),
+			}
+
+			s.giftTimerMapMutex.Lock()
+			s.giftTimerMap[key] = gt
+			s.giftTimerMapMutex.Unlock()
+			go func(gt *GiftWithTimer) {
+				defer gt.Timer.Stop()
+				<-gt.Timer.C
+
+				s.giftTimerMapMutex.Lock()
+				delete(s.giftTimerMap, key)
+				s.giftTimerMapMutex.Unlock()
+
+				gift := gt.Gift
+				gift.GiftNum = int(atomic.LoadInt32(&gt.GiftNum))
+				if text, ok := s.renderTTS(TemplateGift, &gift); ok {
+					s.pushTTS(&tts.NewTaskParams{
+						Text:     text,
+						Uname:    gift.Uname,
+						Event:    config.VoiceEventGift,
+						Priority: tts.PriorityGift,
+					}, false)
+				}
+			}(gt)
+			break
+		}
+	case *proto.CmdGuardData:
+		{
+			u := UserData{
+				OpenID:                 d.UserInfo.OpenID,
+				Uname:                  d.UserInfo.Uname,
+				UFace:                  convertImgUrl(d.UserInfo.Uface),
+				FansMedalLevel:         d.FansMedalLevel,
+				FansMedalName:          d.FansMedalName,
+				FansMedalWearingStatus: d.FansMedalWearingStatus,
+				GuardLevel:             d.GuardLevel,
+			}
+			guardData := &GuardData{
+				UserData:   u,
+				GuardLevel: d.GuardLevel,
+				GuardNum:   d.GuardNum,
+				GuardUnit:  d.GuardUnit,
+				Timestamp:  d.Timestamp,
+				MsgID:      d.MsgID,
+			}
+			fr := s.applyFilter(&guardData.UserData, d.MsgID, nil)
+			if !fr.Drop {
+				s.Broadcast(ResultTypeGuard, guardData)
+			}
+			s.saveEvent(u, &dao.GuardEvent{
+				Event:      s.newEvent(u, d.MsgID, d.Timestamp),
+				GuardLevel: guardData.GuardLevel,
+				GuardNum:   guardData.GuardNum,
+				GuardUnit:  guardData.GuardUnit,
+			})
+			if fr.Drop || fr.SkipTTS {
+				break
+			}
+			if text, ok := s.renderTTS(TemplateGuard, guardData); ok {
+				s.pushTTS(&tts.NewTaskParams{
+					Text:     text,
+					Uname:    guardData.Uname,
+					Event:    config.VoiceEventSystem,
+					Priority: tts.PrioritySuperChat,
+				}, false)
+			}
+			break
+		}
+	case *proto.CmdLiveStartData:
+		{
+			if text, ok := s.renderTTS(TemplateLiveStart, s.roomData); ok {
+				s.pushTTS(&tts.NewTaskParams{
+					Text:     text,
+					Event:    config.VoiceEventSystem,
+					Priority: tts.PrioritySuperChat,
+				}, true)
+			}
+			s.isLiving.Store(true)
+			go s.startLiveSession(time.Unix(d.Timestamp, 0))
+			break
+		}
+	case *proto.CmdLiveEndData:
+		{
+			if text, ok := s.renderTTS(TemplateLiveEnd, s.roomData); ok {
+				s.pushTTS(&tts.NewTaskParams{
+					Text:     text,
+					Event:    config.VoiceEventSystem,
+					Priority: tts.PrioritySuperChat,
+				}, true)
+			}
+			s.isLiving.Store(false)
+			go s.endLiveSession(time.Unix(d.Timestamp, 0))
+			break
+		}
+	case *proto.CmdLiveRoomEnterData:
+		{
+			u := UserData{
+				OpenID: d.OpenID,
+				Uname:  d.Uname,
+				UFace:  d.Uface,
+			}
+			enterData := &RoomEnterData{
+				UserData:  u,
+				Timestamp: d.Timestamp,
+			}
+			fr := s.applyFilter(&enterData.UserData, "", nil)
+			if fr.Drop {
+				break
+			}
+			s.Broadcast(ResultTypeEnterRoom, enterData)
+			if fr.SkipTTS {
+				break
+			}
+
+			s.lastEnterUser.Store(&enterData.UserData)
+
+			go func(openId string) {
+				u, err := s.h.Dao.GetUser(context.Background(), openId)
+				if err != nil {
+					log.Errorf("GetUser open_id: %s err: %v", openId, err)
+					return
+				}
+
+				if u == nil {
+					return
+				}
+
+				if (u.FansMedalWearingStatus && u.FansMedalLevel >= s.Profile().RoomEnterTTSFansMedalLevel) ||
+					u.GuardLevel > 0 {
+
+					data := *enterData
+					data.GuardLevel = u.GuardLevel
+					if text, ok := s.renderTTS(TemplateRoomEnter, &data); ok {
+						s.pushTTS(&tts.NewTaskParams{
+							Text:     text,
+							Uname:    data.Uname,
+							Event:    config.VoiceEventEnter,
+							Priority: tts.PriorityWelcome,
+						}, false)
+					}
+				}
+			}(d.OpenID)
+
+			break
+		}
+	default:
+		{
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"blive-vup-layer/bilibilitest"
+	"blive-vup-layer/config"
+	"blive-vup-layer/dao"
+	"blive-vup-layer/tts"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtb-link/bianka/proto"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkSynthesizer 把文本分两段写入，整段文本作为一条字幕
+type chunkSynthesizer struct{}
+
+func (chunkSynthesizer) Name() string { return "chunk" }
+
+func (chunkSynthesizer) Synthesize(ctx context.Context, req *tts.SynthesisRequest, w io.Writer) error {
+	if req.OnSubtitles != nil {
+		req.OnSubtitles([]*tts.Subtitle{{
+			Text:     req.Text,
+			Sentence: true,
+			EndIndex: len([]rune(req.Text)),
+			EndTime:  1000,
+		}})
+	}
+	w.Write([]byte("audio:"))
+	w.Write([]byte(req.Text))
+	return nil
+}
+
+// newTestHandler 创建连接到本地开放平台的 Handler，工作目录会切换到临时目录
+// 合成后端替换为 chunkSynthesizer，测试不会访问阿里云
+func newTestHandler(t *testing.T, srv *bilibilitest.Server) (*Handler, *httptest.Server) {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir err: %v", err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	cfgContent := fmt.Sprintf(`
+db_path = ":memory:"
+
+[qianfan]
+[aliyun_tts]
+
+[admin]
+token = "admin_token"
+
+[bilibili]
+disable_validate_sign = true
+open_platform_host = "%s"
+`, srv.URL)
+	if err := os.WriteFile("config.toml", []byte(cfgContent), 0644); err != nil {
+		t.Fatalf("WriteFile err: %v", err)
+	}
+	cfg, err := config.ParseConfig("config.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig err: %v", err)
+	}
+
+	h, err := NewHandler(cfg, io.Discard)
+	if err != nil {
+		t.Fatalf("NewHandler err: %v", err)
+	}
+	t.Cleanup(h.TTS.Close)
+	h.TTS.SetSynthesizer(chunkSynthesizer{})
+
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.GET("/server/ws", h.WebSocket)
+	httpSrv := httptest.NewServer(g)
+	t.Cleanup(httpSrv.Close)
+	return h, httpSrv
+}
+
+func dialTestClient(t *testing.T, httpSrv *httptest.Server, code string) *websocket.Conn {
+	return dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:   code,
+		Config: LiveConfig{DisableLlm: true},
+	})
+}
+
+func dialTestClientWithInit(t *testing.T, httpSrv *httptest.Server, initData *InitRequestData) *websocket.Conn {
+	wsUrl := "ws" + strings.TrimPrefix(httpSrv.URL, "http") + "/server/ws"
+	c, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatalf("Dial err: %v", err)
+	}
+
+	data, _ := json.Marshal(initData)
+	if err := c.WriteJSON(&WebSocketRequest{
+		Type: RequestTypeInit,
+		Data: data,
+	}); err != nil {
+		t.Fatalf("WriteJSON err: %v", err)
+	}
+	if _, err := readTestResult(c, ResultTypeRoom); err != nil {
+		t.Fatalf("wait room result err: %v", err)
+	}
+	return c
+}
+
+// readTestResult 读取指定类型的结果，忽略其他类型
+func readTestResult(c *websocket.Conn, resultType string) (*WebSocketResult, error) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var res WebSocketResult
+		if err := c.ReadJSON(&res); err != nil {
+			return nil, err
+		}
+		if res.Type == resultType {
+			return &res, nil
+		}
+	}
+}
+
+func TestSessionShared(t *testing.T) {
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	_, httpSrv := newTestHandler(t, srv)
+
+	c1 := dialTestClient(t, httpSrv, "code")
+	c2 := dialTestClient(t, httpSrv, "code")
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+	assert.Equal(t, 1, srv.Games())
+
+	err := srv.SendDanmu(&proto.CmdDanmuData{
+		OpenID: "open_id",
+		Uname:  "test",
+		Msg:    "hello",
+		MsgID:  "msg_id",
+	})
+	assert.NoError(t, err)
+
+	for _, c := range []*websocket.Conn{c1, c2} {
+		res, err := readTestResult(c, ResultTypeDanmu)
+		if err != nil {
+			t.Fatalf("read danmu err: %v", err)
+		}
+		data := res.Data.(map[string]interface{})
+		assert.Equal(t, "hello", data["msg"])
+		assert.Equal(t, "test", data["uname"])
+	}
+
+	err = srv.SendGuard(&proto.CmdGuardData{
+		GuardLevel: 3,
+		GuardNum:   1,
+		GuardUnit:  "月",
+		MsgID:      "guard_msg_id",
+	})
+	assert.NoError(t, err)
+	res, err := readTestResult(c1, ResultTypeGuard)
+	if err != nil {
+		t.Fatalf("read guard err: %v", err)
+	}
+	assert.Equal(t, float64(3), res.Data.(map[string]interface{})["guard_level"])
+
+	// 最后一个连接离开时才结束场次
+	c1.Close()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 0, srv.Ends())
+
+	c2.Close()
+	assert.Eventually(t, func() bool {
+		return srv.Ends() == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 0, srv.Games())
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
+
+func TestSessionLastEnterUser(t *testing.T) {
+	duration := LastEnterUserDuration
+	LastEnterUserDuration = 20 * time.Millisecond
+	defer func() {
+		LastEnterUserDuration = duration
+	}()
+
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	_, httpSrv := newTestHandler(t, srv)
+
+	c := dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:      "code",
+		Config:    LiveConfig{DisableLlm: true},
+		PlayAudio: true,
+	})
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+
+	// 进入直播间的事件和定时器同时触发
+	for i := 0; i < 10; i++ {
+		err := srv.SendRoomEnter(&proto.CmdLiveRoomEnterData{
+			OpenID: fmt.Sprintf("open_id_%d", i),
+			Uname:  fmt.Sprintf("user%d", i),
+		})
+		assert.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	res, err := readTestResult(c, ResultTypeTTS)
+	if err != nil {
+		t.Fatalf("read tts err: %v", err)
+	}
+	assert.Equal(t, CodeOK, res.Code)
+
+	c.Close()
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
+
+func TestSessionPlayAudio(t *testing.T) {
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	_, httpSrv := newTestHandler(t, srv)
+
+	// 第一个请求播放的连接播放音频
+	c1 := dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:      "code",
+		Config:    LiveConfig{DisableLlm: true},
+		PlayAudio: true,
+	})
+	c2 := dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:      "code",
+		PlayAudio: true,
+	})
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+
+	listen := func(c *websocket.Conn) <-chan string {
+		ch := make(chan string, 8)
+		go func() {
+			for {
+				var res WebSocketResult
+				if err := c.ReadJSON(&res); err != nil {
+					return
+				}
+				if res.Type == ResultTypeTTS {
+					ch <- res.Data.(map[string]interface{})["task_id"].(string)
+				}
+			}
+		}()
+		return ch
+	}
+	recv := func(ch <-chan string) string {
+		select {
+		case taskId := <-ch:
+			return taskId
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait tts timeout")
+		}
+		return ""
+	}
+	ack := func(c *websocket.Conn, taskId string) {
+		data, _ := json.Marshal(&TTSAckRequestData{TaskId: taskId})
+		assert.NoError(t, c.WriteJSON(&WebSocketRequest{
+			Type: RequestTypeTTSPlayed,
+			Data: data,
+		}))
+	}
+	ch1, ch2 := listen(c1), listen(c2)
+
+	for _, msg := range []string{"first", "second", "third"} {
+		err := srv.SendSuperChat(&proto.CmdSuperChatData{
+			OpenID:  "open_id",
+			Uname:   "test",
+			Message: msg,
+			MsgID:   "msg_id_" + msg,
+			Rmb:     30,
+		})
+		assert.NoError(t, err)
+	}
+	taskId := recv(ch1)
+
+	// 其他连接的确认被忽略
+	ack(c2, taskId)
+	select {
+	case <-ch1:
+		t.Fatalf("tts released by other connection")
+	case <-time.After(300 * time.Millisecond):
+	}
+	ack(c1, taskId)
+	taskId = recv(ch1)
+
+	// 播放音频的连接断开后由其他请求播放的连接接替
+	c1.Close()
+	assert.Eventually(t, func() bool {
+		ack(c2, taskId)
+		select {
+		case <-ch2:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+
+	c2.Close()
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
+
+func TestSessionCommandDanmuSaved(t *testing.T) {
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	h, httpSrv := newTestHandler(t, srv)
+
+	err := h.Dao.SetRole(context.Background(), &dao.UserRole{
+		OpenID: "owner_id",
+		Uname:  "owner",
+		Role:   dao.RoleOwner,
+	})
+	assert.NoError(t, err)
+
+	c := dialTestClient(t, httpSrv, "code")
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+
+	err = srv.SendDanmu(&proto.CmdDanmuData{
+		OpenID: "owner_id",
+		Uname:  "owner",
+		Msg:    "/llm off",
+		MsgID:  "command_msg_id",
+	})
+	assert.NoError(t, err)
+	if _, err := readTestResult(c, ResultTypeCommand); err != nil {
+		t.Fatalf("read command err: %v", err)
+	}
+
+	// 指令弹幕也要保存到历史记录
+	assert.Eventually(t, func() bool {
+		page, err := h.Dao.ListDanmuEvents(context.Background(), &dao.EventQuery{OpenID: "owner_id"})
+		return err == nil && page.Total == 1 && page.Events[0].Msg == "/llm off"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	c.Close()
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
+
+func TestSessionMuteRequiresAdmin(t *testing.T) {
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	_, httpSrv := newTestHandler(t, srv)
+
+	c1 := dialTestClient(t, httpSrv, "code")
+	c2 := dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:       "code",
+		AdminToken: "admin_token",
+	})
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+
+	mute := func(c *websocket.Conn) *WebSocketResult {
+		data, _ := json.Marshal(&MuteRequestData{OpenID: "open_id", Uname: "test"})
+		assert.NoError(t, c.WriteJSON(&WebSocketRequest{
+			Type: RequestTypeMute,
+			Data: data,
+		}))
+		res, err := readTestResult(c, ResultTypeMute)
+		if err != nil {
+			t.Fatalf("read mute err: %v", err)
+		}
+		return res
+	}
+
+	// 没有管理 Token 的连接不能禁止用户
+	assert.Equal(t, CodeForbidden, mute(c1).Code)
+	assert.Equal(t, CodeOK, mute(c2).Code)
+
+	c1.Close()
+	c2.Close()
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
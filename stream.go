@@ -0,0 +1,71 @@
+package main
+
+import (
+	"blive-vup-layer/tts"
+	"github.com/gin-gonic/gin"
+)
+
+// ttsStream 把合成中的音频推送给播放音频并且开启了 stream_audio 的连接
+// 音频数据使用二进制帧：第1个字节为任务ID长度，之后是任务ID和音频数据
+// 每个任务开始时推送 ResultTypeTTSStart，结束时推送 ResultTypeTTSEnd
+// 任务按播放顺序依次推送，前端播放完成后发送 tts_played 才会推送下一个任务
+type ttsStream struct {
+	s *Session
+}
+
+var _ tts.StreamHandler = (*ttsStream)(nil)
+
+func (t *ttsStream) streamConn() *WebSocketConn {
+	conn := t.s.audioConn()
+	if conn == nil || !conn.StreamAudio() {
+		return nil
+	}
+	return conn
+}
+
+func (t *ttsStream) OnStart(taskId string, req *tts.SynthesisRequest) {
+	conn := t.streamConn()
+	if conn == nil {
+		return
+	}
+	conn.WriteResultOK(ResultTypeTTSStart, gin.H{
+		"task_id":     taskId,
+		"text":        tts.PlainText(req.Text),
+		"format":      req.Format,
+		"sample_rate": req.SampleRate,
+	})
+}
+
+func (t *ttsStream) OnChunk(taskId string, data []byte) {
+	conn := t.streamConn()
+	if conn == nil {
+		return
+	}
+	frame := make([]byte, 0, 1+len(taskId)+len(data))
+	frame = append(frame, byte(len(taskId)))
+	frame = append(frame, taskId...)
+	frame = append(frame, data...)
+	conn.WriteBinary(frame)
+}
+
+func (t *ttsStream) OnEnd(r *tts.TaskResult) {
+	conn := t.streamConn()
+	if conn == nil {
+		return
+	}
+	if r.Err != nil {
+		conn.WriteResult(&WebSocketResult{
+			Type: ResultTypeTTSEnd,
+			Code: CodeInternalError,
+			Msg:  r.Err.Error(),
+			Data: gin.H{"task_id": r.TaskId},
+		})
+		return
+	}
+	conn.WriteResultOK(ResultTypeTTSEnd, gin.H{
+		"task_id":         r.TaskId,
+		"audio_file_path": r.Fname,
+		"duration":        r.Duration.Milliseconds(),
+		"subtitles":       r.Subtitles,
+	})
+}
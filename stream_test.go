@@ -0,0 +1,75 @@
+package main
+
+import (
+	"blive-vup-layer/bilibilitest"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtb-link/bianka/proto"
+	"testing"
+	"time"
+)
+
+func TestSessionStreamAudio(t *testing.T) {
+	srv := bilibilitest.NewServer()
+	defer srv.Close()
+	_, httpSrv := newTestHandler(t, srv)
+
+	c := dialTestClientWithInit(t, httpSrv, &InitRequestData{
+		Code:        "code",
+		Config:      LiveConfig{DisableLlm: true},
+		StreamAudio: true,
+		PlayAudio:   true,
+	})
+	if err := srv.WaitConnected(1, 5*time.Second); err != nil {
+		t.Fatalf("WaitConnected err: %v", err)
+	}
+
+	err := srv.SendSuperChat(&proto.CmdSuperChatData{
+		OpenID:  "open_id",
+		Uname:   "test",
+		Message: "hello",
+		MsgID:   "msg_id",
+		Rmb:     30,
+	})
+	assert.NoError(t, err)
+
+	var (
+		taskId string
+		audio  []byte
+	)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msgType, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage err: %v", err)
+		}
+		if msgType == websocket.BinaryMessage {
+			idLen := int(msg[0])
+			assert.Equal(t, taskId, string(msg[1:1+idLen]))
+			audio = append(audio, msg[1+idLen:]...)
+			continue
+		}
+
+		var res WebSocketResult
+		assert.NoError(t, json.Unmarshal(msg, &res))
+		assert.NotEqual(t, ResultTypeTTS, res.Type)
+		if res.Type == ResultTypeTTSStart {
+			taskId = res.Data.(map[string]interface{})["task_id"].(string)
+		}
+		if res.Type == ResultTypeTTSEnd {
+			assert.Equal(t, CodeOK, res.Code)
+			data := res.Data.(map[string]interface{})
+			assert.Equal(t, taskId, data["task_id"])
+			assert.Len(t, data["subtitles"], 1)
+			break
+		}
+	}
+	assert.NotEmpty(t, taskId)
+	assert.Contains(t, string(audio), "audio:")
+	assert.Contains(t, string(audio), "hello")
+
+	// 等待会话关闭长连后再关闭服务，否则长连同时被两边关闭
+	c.Close()
+	assert.NoError(t, srv.WaitDisconnected(5*time.Second))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"blive-vup-layer/config"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	TemplateDanmu     = "danmu"
+	TemplateSuperChat = "super_chat"
+	TemplateGift      = "gift"
+	TemplateGuard     = "guard"
+	TemplateLiveStart = "live_start"
+	TemplateLiveEnd   = "live_end"
+	TemplateRoomEnter = "room_enter"
+
+	TemplateLiveSummary = "live_summary" // 可选
+)
+
+var optionalTemplates = map[string]struct{}{
+	TemplateLiveSummary: {},
+}
+
+var templateFuncMap = template.FuncMap{
+	"guardName": getGuardLevelName,
+}
+
+// textTemplate text/template 或者 SSML 使用的 html/template
+type textTemplate interface {
+	Name() string
+	Execute(w io.Writer, data any) error
+}
+
+func parseTemplate(name, text string, ssml bool) (textTemplate, error) {
+	if ssml {
+		// html/template 会转义变量中的 <、& 等字符，模板中的标签保持不变
+		return htmltemplate.New(name).
+			Funcs(htmltemplate.FuncMap(templateFuncMap)).
+			Option("missingkey=error").
+			Parse(text)
+	}
+	return template.New(name).
+		Funcs(templateFuncMap).
+		Option("missingkey=error").
+		Parse(text)
+}
+
+// TextTemplates 编译后的TTS文本模板
+type TextTemplates struct {
+	templates map[string][]textTemplate
+	ssml      bool
+
+	random      *rand.Rand
+	randomMutex sync.Mutex
+}
+
+func NewTextTemplates(cfg *config.TemplatesConfig) (*TextTemplates, error) {
+	texts := map[string][]string{
+		TemplateDanmu:     cfg.Danmu,
+		TemplateSuperChat: cfg.SuperChat,
+		TemplateGift:      cfg.Gift,
+		TemplateGuard:     cfg.Guard,
+		TemplateLiveStart: cfg.LiveStart,
+		TemplateLiveEnd:   cfg.LiveEnd,
+		TemplateRoomEnter: cfg.RoomEnter,
+
+		TemplateLiveSummary: cfg.LiveSummary,
+	}
+
+	templates := make(map[string][]textTemplate, len(texts))
+	for name, variants := range texts {
+		if _, ok := optionalTemplates[name]; !ok && len(variants) == 0 {
+			return nil, fmt.Errorf("template %s is empty", name)
+		}
+		for i, text := range variants {
+			tpl, err := parseTemplate(fmt.Sprintf("%s-%d", name, i), text, cfg.SSML)
+			if err != nil {
+				return nil, fmt.Errorf("parse template %s[%d] err: %w", name, i, err)
+			}
+			templates[name] = append(templates[name], tpl)
+		}
+	}
+
+	return &TextTemplates{
+		templates: templates,
+		ssml:      cfg.SSML,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Has 模板是否已配置，用于可选模板
+func (t *TextTemplates) Has(name string) bool {
+	return len(t.templates[name]) > 0
+}
+
+// Render 随机选择一个模板渲染
+func (t *TextTemplates) Render(name string, data interface{}) (string, error) {
+	variants, ok := t.templates[name]
+	if !ok || len(variants) == 0 {
+		return "", fmt.Errorf("template %s not found", name)
+	}
+
+	t.randomMutex.Lock()
+	tpl := variants[t.random.Intn(len(variants))]
+	t.randomMutex.Unlock()
+
+	sb := strings.Builder{}
+	if err := tpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("execute template %s err: %w", tpl.Name(), err)
+	}
+	text := strings.TrimSpace(sb.String())
+	if t.ssml && !strings.HasPrefix(text, "<speak") {
+		text = "<speak>" + text + "</speak>"
+	}
+	return text, nil
+}
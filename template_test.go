@@ -0,0 +1,55 @@
+package main
+
+import (
+	"blive-vup-layer/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTextTemplates(t *testing.T) {
+	templates, err := NewTextTemplates(&config.DefaultTemplatesConfig)
+	if err != nil {
+		t.Errorf("NewTextTemplates err: %v", err)
+		return
+	}
+
+	text, err := templates.Render(TemplateDanmu, &DanmuData{
+		UserData: UserData{Uname: "test"},
+		Msg:      "hello",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test说：hello", text)
+
+	text, err = templates.Render(TemplateGuard, &GuardData{
+		UserData:   UserData{Uname: "test"},
+		GuardLevel: 3,
+		GuardNum:   1,
+		GuardUnit:  "月",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "谢谢test酱赠送的1个月舰长，么么哒", text)
+
+	text, err = templates.Render(TemplateRoomEnter, &RoomEnterData{
+		UserData: UserData{Uname: "test", GuardLevel: 2},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "欢迎提督test酱来到直播间", text)
+}
+
+func TestTextTemplatesSSML(t *testing.T) {
+	cfg := config.DefaultTemplatesConfig
+	cfg.SSML = true
+	cfg.Danmu = []string{`{{.Uname}}说<break time="300ms"/>{{.Msg}}`}
+	templates, err := NewTextTemplates(&cfg)
+	if err != nil {
+		t.Errorf("NewTextTemplates err: %v", err)
+		return
+	}
+
+	text, err := templates.Render(TemplateDanmu, &DanmuData{
+		UserData: UserData{Uname: "test"},
+		Msg:      "<b>a&b</b>",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `<speak>test说<break time="300ms"/>&lt;b&gt;a&amp;b&lt;/b&gt;</speak>`, text)
+}
@@ -0,0 +1,171 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	nls "blive-vup-layer/tts/alibabacloud-nls-go-sdk"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	syslog "log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const aliyunSynthesisTimeout = 60 * time.Second
+
+type aliyunTaskFailed struct {
+	Header struct {
+		Status     int    `json:"status"`
+		StatusText string `json:"status_text"`
+	} `json:"header"`
+}
+
+// aliyunFailedError 根据 TaskFailed 消息的状态码分类
+func aliyunFailedError(text string) error {
+	var msg aliyunTaskFailed
+	if err := json.Unmarshal([]byte(text), &msg); err != nil || msg.Header.Status == 0 {
+		return newSynthesisError(classifyMessage(text), fmt.Errorf("task failed: %s", text))
+	}
+	return newSynthesisError(aliyunStatusKind(msg.Header.Status),
+		fmt.Errorf("task failed: %d %s", msg.Header.Status, msg.Header.StatusText))
+}
+
+type aliyunMetaInfo struct {
+	Payload struct {
+		Subtitles []*Subtitle `json:"subtitles"`
+	} `json:"payload"`
+}
+
+// AliyunSynthesizer 阿里云智能语音交互
+type AliyunSynthesizer struct {
+	cfg    *config.AliyunTTSConfig
+	tokens *TokenManager
+
+	longTextThreshold int // 超过这个字数时使用长文本语音合成，为0时不使用
+}
+
+func NewAliyunSynthesizer(cfg *config.AliyunTTSConfig, tokens *TokenManager, longTextThreshold int) *AliyunSynthesizer {
+	return &AliyunSynthesizer{cfg: cfg, tokens: tokens, longTextThreshold: longTextThreshold}
+}
+
+func (a *AliyunSynthesizer) Name() string { return config.TTSBackendAliyun }
+
+// aliyunOutput SDK 的回调在 Synthesize 超时或者取消返回后仍然可能被调用，关闭后丢弃数据
+type aliyunOutput struct {
+	w           io.Writer
+	onSubtitles func(subtitles []*Subtitle)
+	err         error
+	closed      bool
+	mutex       sync.Mutex
+}
+
+func (o *aliyunOutput) write(data []byte) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed || o.err != nil {
+		return
+	}
+	_, o.err = o.w.Write(data)
+}
+
+func (o *aliyunOutput) subtitles(subtitles []*Subtitle) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return
+	}
+	o.onSubtitles(subtitles)
+}
+
+// close 返回写入错误
+func (o *aliyunOutput) close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.closed = true
+	return o.err
+}
+
+func (a *AliyunSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	l := log.WithField("synthesizer", a.Name())
+	nlsLog := nls.NewNlsLogger(io.Discard, "NLS", syslog.LstdFlags|syslog.Lmicroseconds)
+	//nlsLog.SetDebug(true)
+
+	token, err := a.tokens.Token()
+	if err != nil {
+		return newSynthesisError(ClassifyError(err), fmt.Errorf("get token err: %w", err))
+	}
+	nlsCfg := nls.NewConnectionConfigWithToken(nls.DEFAULT_URL, a.cfg.AppKey, token)
+
+	param := nls.SpeechSynthesisStartParam{
+		Voice:          req.Voice,
+		Format:         req.Format,
+		SampleRate:     req.SampleRate,
+		Volume:         req.Volume,
+		SpeechRate:     req.SpeechRate,
+		PitchRate:      req.PitchRate,
+		EnableSubtitle: req.OnSubtitles != nil,
+	}
+	out := &aliyunOutput{w: w, onSubtitles: req.OnSubtitles}
+	defer out.close()
+	failed := make(chan string, 1)
+	long := a.longTextThreshold > 0 && utf8.RuneCountInString(req.Text) > a.longTextThreshold
+	ss, err := nls.NewSpeechSynthesis(nlsCfg, nlsLog, long,
+		func(text string, param interface{}) {
+			l.Errorf("TaskFailed: %s", text)
+			select {
+			case failed <- text:
+			default:
+			}
+		},
+		func(data []byte, param interface{}) {
+			out.write(data)
+		},
+		func(text string, param interface{}) {
+			if req.OnSubtitles == nil {
+				return
+			}
+			var meta aliyunMetaInfo
+			if err := json.Unmarshal([]byte(text), &meta); err != nil {
+				l.Errorf("parse MetaInfo err: %v", err)
+				return
+			}
+			out.subtitles(meta.Payload.Subtitles)
+		},
+		func(text string, param interface{}) {
+			l.Infof("onCompleted: %s", text)
+		},
+		func(param interface{}) {
+			l.Infof("onClosed")
+		},
+		param)
+	if err != nil {
+		return newSynthesisError(ClassifyError(err), fmt.Errorf("NewSpeechSynthesis err: %w", err))
+	}
+	defer ss.Shutdown()
+
+	ch, err := ss.Start(req.Text, param, nil)
+	if err != nil {
+		return newSynthesisError(ClassifyError(err), fmt.Errorf("Start err: %w", err))
+	}
+
+	select {
+	case done := <-ch:
+		if !done {
+			select {
+			case text := <-failed:
+				return aliyunFailedError(text)
+			default:
+				return newSynthesisError(ErrorKindNetwork, errors.New("wait failed"))
+			}
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(aliyunSynthesisTimeout):
+		return newSynthesisError(ErrorKindTimeout, errors.New("wait timeout"))
+	}
+	return out.close()
+}
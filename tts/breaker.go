@@ -0,0 +1,267 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("tts circuit breaker open")
+
+const (
+	probeText    = "测试"
+	probeTimeout = 30 * time.Second
+)
+
+// BreakerStatus 合成后端的熔断状态
+type BreakerStatus struct {
+	Backend  string     `json:"backend"`
+	Open     bool       `json:"open"`                // 熔断中，暂停使用这个后端
+	Kind     ErrorKind  `json:"kind,omitempty"`      // 最近一次失败的原因
+	Error    string     `json:"error,omitempty"`     // 最近一次失败的错误信息
+	OpenTime *time.Time `json:"open_time,omitempty"` // 开始熔断的时间
+}
+
+// breakerSynthesizer 带熔断的合成后端，组合的合成后端需要转发给下层
+type breakerSynthesizer interface {
+	Synthesizer
+	Statuses() []*BreakerStatus
+	Probe(ctx context.Context)
+	breakers() []*ResilientSynthesizer
+}
+
+func synthesizerStatuses(s Synthesizer) []*BreakerStatus {
+	if b, ok := s.(breakerSynthesizer); ok {
+		return b.Statuses()
+	}
+	return nil
+}
+
+func probeSynthesizer(ctx context.Context, s Synthesizer) {
+	if b, ok := s.(breakerSynthesizer); ok {
+		b.Probe(ctx)
+	}
+}
+
+func synthesizerBreakers(s Synthesizer) []*ResilientSynthesizer {
+	if b, ok := s.(breakerSynthesizer); ok {
+		return b.breakers()
+	}
+	return nil
+}
+
+// inheritBreakers 配置热更新重建合成后端时，沿用旧合成后端中同名后端的熔断状态
+func inheritBreakers(s, old Synthesizer) {
+	olds := make(map[string]*ResilientSynthesizer)
+	for _, b := range synthesizerBreakers(old) {
+		olds[b.Name()] = b
+	}
+	for _, b := range synthesizerBreakers(s) {
+		if o, ok := olds[b.Name()]; ok {
+			b.inherit(o)
+		}
+	}
+}
+
+// ResilientSynthesizer 网络错误和超时时重试，连续失败或者鉴权、额度错误时熔断
+// 熔断期间直接返回 ErrCircuitOpen，每隔 probe_interval 放行一次请求探测是否恢复，
+// 没有请求时通过 Probe 探测
+type ResilientSynthesizer struct {
+	Synthesizer
+	cfg      *config.TTSRetryConfig
+	onChange func(status *BreakerStatus)
+
+	failures  int
+	status    BreakerStatus
+	nextProbe time.Time
+	probing   bool
+	probeReq  *SynthesisRequest // 探测时使用最近一次的合成参数
+	mutex     sync.Mutex
+}
+
+// NewResilientSynthesizer onChange 在熔断和恢复时调用，可以为 nil
+func NewResilientSynthesizer(s Synthesizer, cfg *config.TTSRetryConfig, onChange func(status *BreakerStatus)) *ResilientSynthesizer {
+	return &ResilientSynthesizer{
+		Synthesizer: s,
+		cfg:         cfg,
+		onChange:    onChange,
+		status:      BreakerStatus{Backend: s.Name()},
+	}
+}
+
+func (r *ResilientSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	r.mutex.Lock()
+	status := r.status
+	probeReq := *req
+	probeReq.OnSubtitles = nil
+	probeReq.OnFallback = nil
+	r.probeReq = &probeReq
+	probe := status.Open && r.startProbe()
+	r.mutex.Unlock()
+	if probe {
+		// 半开状态，这次请求用于探测，不重试
+		attemptReq, commit := attemptRequest(req)
+		err := r.Synthesizer.Synthesize(ctx, attemptReq, w)
+		if err == nil {
+			commit()
+		}
+		r.finishProbe(ctx, err)
+		return err
+	}
+	if status.Open {
+		return newSynthesisError(status.Kind, ErrCircuitOpen)
+	}
+
+	for attempt := 0; ; attempt++ {
+		cw := &countingWriter{w: w}
+		attemptReq, commit := attemptRequest(req)
+		err := r.Synthesizer.Synthesize(ctx, attemptReq, cw)
+		if err == nil {
+			commit()
+			r.succeed()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		kind := ClassifyError(err)
+		// 已经写入部分数据时不能重试
+		if !kind.Transient() || cw.n > 0 || attempt >= r.cfg.MaxRetries {
+			r.fail(kind, err)
+			return err
+		}
+		backoff := r.backoff(attempt)
+		log.Warnf("synthesizer %s %s error, retry in %s: %v", r.Name(), kind, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// backoff 第 attempt 次重试前的等待时间，在 [d/2, d] 之间随机
+func (r *ResilientSynthesizer) backoff(attempt int) time.Duration {
+	d := r.cfg.RetryBackoff.Duration() << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (r *ResilientSynthesizer) succeed() {
+	r.mutex.Lock()
+	r.failures = 0
+	if !r.status.Open {
+		r.mutex.Unlock()
+		return
+	}
+	r.status = BreakerStatus{Backend: r.Name()}
+	status := r.status
+	r.mutex.Unlock()
+
+	log.Infof("synthesizer %s recovered", r.Name())
+	r.notify(&status)
+}
+
+func (r *ResilientSynthesizer) fail(kind ErrorKind, err error) {
+	// 参数错误和后端是否可用无关
+	if kind == ErrorKindInvalid {
+		return
+	}
+	r.mutex.Lock()
+	r.failures++
+	r.status.Kind = kind
+	r.status.Error = err.Error()
+	threshold := r.cfg.BreakerFailures
+	if r.status.Open || threshold <= 0 || (!kind.Persistent() && r.failures < threshold) {
+		r.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	r.status.Open = true
+	r.status.OpenTime = &now
+	r.nextProbe = now.Add(r.cfg.ProbeInterval.Duration())
+	status := r.status
+	r.mutex.Unlock()
+
+	log.Errorf("synthesizer %s circuit breaker open, %d failures, %s: %v", r.Name(), r.failures, kind, err)
+	r.notify(&status)
+}
+
+func (r *ResilientSynthesizer) notify(status *BreakerStatus) {
+	if r.onChange != nil {
+		r.onChange(status)
+	}
+}
+
+// startProbe 熔断超过 probe_interval 并且没有正在探测时开始探测，需要持有 mutex
+func (r *ResilientSynthesizer) startProbe() bool {
+	if r.probing || time.Now().Before(r.nextProbe) {
+		return false
+	}
+	r.probing = true
+	return true
+}
+
+// finishProbe 探测成功时恢复，失败时等待下一个 probe_interval，ctx 取消时不算失败
+func (r *ResilientSynthesizer) finishProbe(ctx context.Context, err error) {
+	r.mutex.Lock()
+	r.probing = false
+	if err != nil && ctx.Err() == nil {
+		r.nextProbe = time.Now().Add(r.cfg.ProbeInterval.Duration())
+		r.status.Kind = ClassifyError(err)
+		r.status.Error = err.Error()
+	}
+	r.mutex.Unlock()
+
+	if err != nil {
+		log.Warnf("synthesizer %s probe failed: %v", r.Name(), err)
+		return
+	}
+	r.succeed()
+}
+
+// Probe 熔断超过 probe_interval 后合成一段短文本，成功时恢复
+func (r *ResilientSynthesizer) Probe(ctx context.Context) {
+	r.mutex.Lock()
+	if !r.status.Open || r.probeReq == nil || !r.startProbe() {
+		r.mutex.Unlock()
+		return
+	}
+	req := *r.probeReq
+	r.mutex.Unlock()
+
+	req.Text = probeText
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	err := r.Synthesizer.Synthesize(probeCtx, &req, io.Discard)
+	cancel()
+	r.finishProbe(ctx, err)
+}
+
+// inherit 复制 old 的失败次数、熔断状态和探测时间
+func (r *ResilientSynthesizer) inherit(old *ResilientSynthesizer) {
+	old.mutex.Lock()
+	failures, status, nextProbe, probeReq := old.failures, old.status, old.nextProbe, old.probeReq
+	old.mutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures = failures
+	r.status = status
+	r.nextProbe = nextProbe
+	r.probeReq = probeReq
+}
+
+func (r *ResilientSynthesizer) breakers() []*ResilientSynthesizer {
+	return []*ResilientSynthesizer{r}
+}
+
+func (r *ResilientSynthesizer) Statuses() []*BreakerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := r.status
+	return []*BreakerStatus{&status}
+}
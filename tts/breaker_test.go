@@ -0,0 +1,212 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{newSynthesisError(ErrorKindQuota, errors.New("x")), ErrorKindQuota},
+		{fmt.Errorf("wrap: %w", context.DeadlineExceeded), ErrorKindTimeout},
+		{errors.New("dial tcp 127.0.0.1:443: connect: connection refused"), ErrorKindNetwork},
+		{errors.New("Forbidden.AccessKeyDisabled"), ErrorKindAuth},
+		{aliyunFailedError(`{"header":{"status":40000001,"status_text":"Gateway:ACCESS_DENIED"}}`), ErrorKindAuth},
+		{aliyunFailedError(`{"header":{"status":40000005,"status_text":"Gateway:TOO_MANY_REQUESTS"}}`), ErrorKindQuota},
+		{aliyunFailedError(`{"header":{"status":40000003,"status_text":"Gateway:PARAMETER_INVALID"}}`), ErrorKindInvalid},
+		{aliyunFailedError(`{"header":{"status":50000000,"status_text":"GRPC_ERROR"}}`), ErrorKindNetwork},
+		{errors.New("something else"), ErrorKindUnknown},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ClassifyError(tt.err), tt.err.Error())
+	}
+}
+
+// flakySynthesizer 按顺序返回 errs 中的错误，用完后成功，每次尝试都会产生一条字幕
+type flakySynthesizer struct {
+	errs  []error
+	calls int
+}
+
+func (f *flakySynthesizer) Name() string { return "flaky" }
+
+func (f *flakySynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	f.calls++
+	if req.OnSubtitles != nil {
+		req.OnSubtitles([]*Subtitle{{Text: req.Text, EndTime: f.calls}})
+	}
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	_, err := w.Write([]byte("audio"))
+	return err
+}
+
+func TestResilientSynthesizer(t *testing.T) {
+	cfg := &config.TTSRetryConfig{
+		MaxRetries:      2,
+		RetryBackoff:    config.Duration(time.Millisecond),
+		BreakerFailures: 3,
+		ProbeInterval:   config.Duration(10 * time.Millisecond),
+	}
+	var changes []*BreakerStatus
+	onChange := func(status *BreakerStatus) {
+		changes = append(changes, status)
+	}
+	req := &SynthesisRequest{Text: "test"}
+	networkErr := newSynthesisError(ErrorKindNetwork, errors.New("reset"))
+
+	// 网络错误重试后成功，只保留成功那次的字幕
+	var subtitles []*Subtitle
+	f := &flakySynthesizer{errs: []error{networkErr, networkErr}}
+	r := NewResilientSynthesizer(f, cfg, onChange)
+	assert.NoError(t, r.Synthesize(context.Background(), &SynthesisRequest{
+		Text: "test",
+		OnSubtitles: func(subs []*Subtitle) {
+			subtitles = append(subtitles, subs...)
+		},
+	}, io.Discard))
+	assert.Equal(t, 3, f.calls)
+	if assert.Len(t, subtitles, 1) {
+		assert.Equal(t, 3, subtitles[0].EndTime)
+	}
+
+	// 参数错误不重试也不熔断
+	f = &flakySynthesizer{errs: []error{
+		newSynthesisError(ErrorKindInvalid, errors.New("bad ssml")),
+		newSynthesisError(ErrorKindInvalid, errors.New("bad ssml")),
+		newSynthesisError(ErrorKindInvalid, errors.New("bad ssml")),
+	}}
+	r = NewResilientSynthesizer(f, cfg, onChange)
+	for i := 0; i < 3; i++ {
+		assert.Error(t, r.Synthesize(context.Background(), req, io.Discard))
+	}
+	assert.Equal(t, 3, f.calls)
+	assert.False(t, r.Statuses()[0].Open)
+
+	// 鉴权错误立即熔断
+	f = &flakySynthesizer{errs: []error{newSynthesisError(ErrorKindAuth, errors.New("denied"))}}
+	r = NewResilientSynthesizer(f, cfg, onChange)
+	assert.Error(t, r.Synthesize(context.Background(), req, io.Discard))
+	assert.True(t, r.Statuses()[0].Open)
+	assert.Equal(t, ErrorKindAuth, r.Statuses()[0].Kind)
+	if assert.Len(t, changes, 1) {
+		assert.True(t, changes[0].Open)
+	}
+
+	err := r.Synthesize(context.Background(), req, io.Discard)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, f.calls)
+
+	// 探测间隔之前不探测，之后探测成功恢复
+	r.Probe(context.Background())
+	assert.Equal(t, 1, f.calls)
+	time.Sleep(20 * time.Millisecond)
+	r.Probe(context.Background())
+	assert.Equal(t, 2, f.calls)
+	assert.False(t, r.Statuses()[0].Open)
+	if assert.Len(t, changes, 2) {
+		assert.False(t, changes[1].Open)
+	}
+	assert.NoError(t, r.Synthesize(context.Background(), req, io.Discard))
+
+	// 连续网络错误达到上限后熔断
+	f = &flakySynthesizer{errs: []error{networkErr, networkErr, networkErr}}
+	r = NewResilientSynthesizer(f, &config.TTSRetryConfig{BreakerFailures: 3, ProbeInterval: cfg.ProbeInterval}, nil)
+	for i := 0; i < 3; i++ {
+		assert.False(t, r.Statuses()[0].Open)
+		assert.Error(t, r.Synthesize(context.Background(), req, io.Discard))
+	}
+	assert.True(t, r.Statuses()[0].Open)
+}
+
+func TestResilientSynthesizerFallback(t *testing.T) {
+	cfg := &config.TTSRetryConfig{
+		BreakerFailures: 3,
+		ProbeInterval:   config.Duration(20 * time.Millisecond),
+	}
+	primary := &flakySynthesizer{errs: []error{
+		newSynthesisError(ErrorKindAuth, errors.New("denied")),
+		newSynthesisError(ErrorKindAuth, errors.New("denied")),
+	}}
+	secondary := &flakySynthesizer{}
+	p := NewResilientSynthesizer(primary, cfg, nil)
+	s := FallbackSynthesizer{p, NewResilientSynthesizer(secondary, cfg, nil)}
+	req := &SynthesisRequest{Text: "test"}
+
+	// 主后端熔断后使用备用后端，探测间隔之前不再请求主后端
+	assert.NoError(t, s.Synthesize(context.Background(), req, io.Discard))
+	assert.True(t, p.Statuses()[0].Open)
+	assert.NoError(t, s.Synthesize(context.Background(), req, io.Discard))
+	assert.Equal(t, 1, primary.calls)
+	assert.Equal(t, 2, secondary.calls)
+
+	// 超过探测间隔后放行一次请求，失败时继续使用备用后端
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, s.Synthesize(context.Background(), req, io.Discard))
+	assert.Equal(t, 2, primary.calls)
+	assert.Equal(t, 3, secondary.calls)
+	assert.True(t, p.Statuses()[0].Open)
+
+	// 探测成功后恢复，不再使用备用后端
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, s.Synthesize(context.Background(), req, io.Discard))
+	assert.Equal(t, 3, primary.calls)
+	assert.Equal(t, 3, secondary.calls)
+	assert.False(t, p.Statuses()[0].Open)
+}
+
+func TestTTSSetConfigKeepBreakers(t *testing.T) {
+	tts := newTestTTS(t, nil)
+	cfg, err := config.ParseConfig("config.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig err: %v", err)
+	}
+	// newTestTTS 替换了合成后端，先按配置重建
+	retry := *cfg.TTSRetry
+	retry.MaxRetries++
+	changed := *cfg
+	changed.TTSRetry = &retry
+	assert.NoError(t, tts.SetConfig(&changed))
+
+	var notified int
+	tts.SetStatusHandler(func(status *BreakerStatus) {
+		notified++
+	})
+	breakers := synthesizerBreakers(tts.Synthesizer())
+	if !assert.Len(t, breakers, 1) {
+		return
+	}
+	breakers[0].fail(ErrorKindAuth, errors.New("auth failed"))
+	assert.Equal(t, 1, notified)
+
+	// 和合成无关的配置变化不重建合成后端
+	same, err := config.ParseConfig("config.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig err: %v", err)
+	}
+	same.TTSRetry = &retry
+	assert.NoError(t, tts.SetConfig(same))
+	assert.Same(t, breakers[0], synthesizerBreakers(tts.Synthesizer())[0])
+
+	// 重建时沿用熔断状态，不会误报恢复
+	assert.NoError(t, tts.SetConfig(cfg))
+	assert.NotSame(t, breakers[0], synthesizerBreakers(tts.Synthesizer())[0])
+	if statuses := tts.Statuses(); assert.Len(t, statuses, 1) {
+		assert.True(t, statuses[0].Open)
+		assert.Equal(t, ErrorKindAuth, statuses[0].Kind)
+	}
+	assert.False(t, tts.Available())
+	assert.Equal(t, 1, notified)
+}
@@ -0,0 +1,172 @@
+package tts
+
+import (
+	"container/list"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type cacheEntry struct {
+	key   string
+	fname string
+	size  int64
+}
+
+// Cache 以内容哈希为文件名的合成结果缓存，超过大小上限时淘汰最久未使用的文件
+type Cache struct {
+	dir     string
+	maxSize atomic.Int64
+
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	mutex   sync.Mutex
+}
+
+// NewCache 创建缓存并加载目录中已有的文件，maxSize 为0时不缓存
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	c.maxSize.Store(maxSize)
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type fileInfo struct {
+		entry   *cacheEntry
+		modTime int64
+	}
+	var files []fileInfo
+	for _, de := range dirEntries {
+		if de.IsDir() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		name := de.Name()
+		files = append(files, fileInfo{
+			entry: &cacheEntry{
+				key:   strings.TrimSuffix(name, path.Ext(name)),
+				fname: path.Join(dir, name),
+				size:  info.Size(),
+			},
+			modTime: info.ModTime().UnixNano(),
+		})
+	}
+	// 最近修改的文件放在前面
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime > files[j].modTime
+	})
+	for _, f := range files {
+		c.entries[f.entry.key] = c.lru.PushBack(f.entry)
+		c.size += f.entry.size
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *Cache) Enabled() bool {
+	return c.maxSize.Load() > 0
+}
+
+// SetMaxSize 修改大小上限，用于配置热更新
+func (c *Cache) SetMaxSize(maxSize int64) {
+	c.maxSize.Store(maxSize)
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+}
+
+// Get 返回缓存的文件路径
+func (c *Cache) Get(key string) (string, bool) {
+	if !c.Enabled() {
+		return "", false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := e.Value.(*cacheEntry)
+	if _, err := os.Stat(entry.fname); err != nil {
+		c.remove(e)
+		return "", false
+	}
+	c.lru.MoveToFront(e)
+	return entry.fname, true
+}
+
+// Put 把合成结果移动到缓存目录，返回缓存的文件路径
+func (c *Cache) Put(key string, fname string) (string, error) {
+	if !c.Enabled() {
+		return fname, nil
+	}
+	info, err := os.Stat(fname)
+	if err != nil {
+		return "", err
+	}
+	cacheFname := path.Join(c.dir, key+path.Ext(fname))
+	if err := os.Rename(fname, cacheFname); err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:   key,
+		fname: cacheFname,
+		size:  info.Size(),
+	})
+	c.size += info.Size()
+	c.evict()
+	return cacheFname, nil
+}
+
+// Size 缓存文件的总大小
+func (c *Cache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+func (c *Cache) evict() {
+	maxSize := c.maxSize.Load()
+	for c.size > maxSize {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+		entry := e.Value.(*cacheEntry)
+		if err := os.Remove(entry.fname); err != nil && !os.IsNotExist(err) {
+			log.Errorf("remove tts cache %s err: %v", entry.fname, err)
+		}
+		c.remove(e)
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.size -= entry.size
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+}
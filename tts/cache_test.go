@@ -0,0 +1,57 @@
+package tts
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(path.Join(dir, "cache"), 10)
+	if err != nil {
+		t.Fatalf("NewCache err: %v", err)
+	}
+
+	writeFile := func(name string, size int) string {
+		fname := path.Join(dir, name)
+		if err := os.WriteFile(fname, make([]byte, size), 0644); err != nil {
+			t.Fatalf("WriteFile err: %v", err)
+		}
+		return fname
+	}
+
+	key1 := (&SynthesisRequest{Text: "a", Voice: "v"}).CacheKey()
+	key2 := (&SynthesisRequest{Text: "a", Voice: "v", PitchRate: 1}).CacheKey()
+	key3 := (&SynthesisRequest{Text: "b", Voice: "v"}).CacheKey()
+	assert.NotEqual(t, key1, key2)
+
+	_, ok := c.Get(key1)
+	assert.False(t, ok)
+
+	fname1, err := c.Put(key1, writeFile("1.wav", 4))
+	assert.NoError(t, err)
+	cached, ok := c.Get(key1)
+	assert.True(t, ok)
+	assert.Equal(t, fname1, cached)
+
+	_, err = c.Put(key2, writeFile("2.wav", 4))
+	assert.NoError(t, err)
+	c.Get(key1)
+
+	// 超过大小上限，淘汰最久未使用的 key2
+	_, err = c.Put(key3, writeFile("3.wav", 4))
+	assert.NoError(t, err)
+	_, ok = c.Get(key2)
+	assert.False(t, ok)
+	_, ok = c.Get(key1)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), c.Size())
+
+	// 重新加载已有的缓存文件
+	c, err = NewCache(path.Join(dir, "cache"), 10)
+	assert.NoError(t, err)
+	_, ok = c.Get(key3)
+	assert.True(t, ok)
+}
@@ -0,0 +1,105 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrorKind 合成失败的原因
+type ErrorKind string
+
+const (
+	ErrorKindAuth    ErrorKind = "auth"    // 鉴权失败，需要检查密钥
+	ErrorKindQuota   ErrorKind = "quota"   // 超过并发、调用次数或者欠费
+	ErrorKindNetwork ErrorKind = "network" // 连接失败或者服务端错误
+	ErrorKindTimeout ErrorKind = "timeout" // 等待合成超时
+	ErrorKindInvalid ErrorKind = "invalid" // 参数或者文本错误，和后端是否可用无关
+	ErrorKindUnknown ErrorKind = "unknown"
+)
+
+// Transient 网络错误和超时可以重试
+func (k ErrorKind) Transient() bool {
+	return k == ErrorKindNetwork || k == ErrorKindTimeout
+}
+
+// Persistent 鉴权和额度错误重试也不会成功，直接熔断
+func (k ErrorKind) Persistent() bool {
+	return k == ErrorKindAuth || k == ErrorKindQuota
+}
+
+// SynthesisError 合成后端返回的已分类错误
+type SynthesisError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *SynthesisError) Error() string {
+	return string(e.Kind) + ": " + e.Err.Error()
+}
+
+func (e *SynthesisError) Unwrap() error { return e.Err }
+
+func newSynthesisError(kind ErrorKind, err error) error {
+	return &SynthesisError{Kind: kind, Err: err}
+}
+
+// ClassifyError 判断错误类型，没有分类的错误根据错误信息判断
+func ClassifyError(err error) ErrorKind {
+	var se *SynthesisError
+	if errors.As(err, &se) {
+		return se.Kind
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return ErrorKindTimeout
+		}
+		return ErrorKindNetwork
+	}
+	return classifyMessage(err.Error())
+}
+
+func classifyMessage(msg string) ErrorKind {
+	msg = strings.ToLower(msg)
+	containsAny := func(subs ...string) bool {
+		for _, s := range subs {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case containsAny("access_denied", "forbidden", "unauthorized", "invalidaccesskey", "signaturedoesnotmatch", "40000001"):
+		return ErrorKindAuth
+	case containsAny("too_many_requests", "throttl", "quota", "insufficient", "40000005", "40000010"):
+		return ErrorKindQuota
+	case containsAny("timeout", "deadline exceeded"):
+		return ErrorKindTimeout
+	case containsAny("connection", "dial", "eof", "broken pipe", "no such host", "network"):
+		return ErrorKindNetwork
+	}
+	return ErrorKindUnknown
+}
+
+// aliyunStatusKind 阿里云 TaskFailed 的状态码
+func aliyunStatusKind(status int) ErrorKind {
+	switch {
+	case status == 40000001:
+		return ErrorKindAuth
+	case status == 40000005 || status == 40000010:
+		return ErrorKindQuota
+	case status == 40000004:
+		return ErrorKindTimeout
+	case status >= 40000000 && status < 50000000:
+		return ErrorKindInvalid
+	case status >= 50000000:
+		return ErrorKindNetwork
+	}
+	return ErrorKindUnknown
+}
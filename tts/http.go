@@ -0,0 +1,78 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPSynthesizer 自建的HTTP合成服务，POST JSON 格式的 SynthesisRequest，返回音频文件
+type HTTPSynthesizer struct {
+	name   string
+	cfg    *config.HTTPTTSConfig
+	client *http.Client
+}
+
+func NewHTTPSynthesizer(name string, cfg *config.HTTPTTSConfig) *HTTPSynthesizer {
+	return &HTTPSynthesizer{
+		name:   name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration()},
+	}
+}
+
+func (h *HTTPSynthesizer) Name() string { return h.name }
+
+func (h *HTTPSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range h.cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return newSynthesisError(httpStatusKind(resp.StatusCode), fmt.Errorf("http status %d: %s", resp.StatusCode, msg))
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return newSynthesisError(ErrorKindNetwork, fmt.Errorf("empty audio"))
+	}
+	return nil
+}
+
+func httpStatusKind(code int) ErrorKind {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrorKindAuth
+	case code == http.StatusTooManyRequests || code == http.StatusPaymentRequired:
+		return ErrorKindQuota
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return ErrorKindTimeout
+	case code >= 500:
+		return ErrorKindNetwork
+	case code >= 400:
+		return ErrorKindInvalid
+	}
+	return ErrorKindUnknown
+}
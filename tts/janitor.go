@@ -0,0 +1,148 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type JanitorStats struct {
+	Files         int       `json:"files"`           // 当前文件数量
+	Bytes         int64     `json:"bytes"`           // 当前文件总大小
+	RemovedFiles  int64     `json:"removed_files"`   // 累计删除的文件数量
+	RemovedBytes  int64     `json:"removed_bytes"`   // 累计删除的文件大小
+	LastCleanTime time.Time `json:"last_clean_time"` // 最后一次清理时间
+}
+
+// Janitor 按保留时间和总大小清理合成结果文件，只处理目录下的文件，不处理子目录中的TTS缓存
+type Janitor struct {
+	dir string
+	cfg atomic.Pointer[config.ResultFilesConfig]
+
+	stats      JanitorStats
+	statsMutex sync.Mutex
+	cleanMutex sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewJanitor(dir string, cfg *config.ResultFilesConfig) *Janitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Janitor{
+		dir:    dir,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	j.SetConfig(cfg)
+	return j
+}
+
+// SetConfig 替换配置，用于配置热更新
+func (j *Janitor) SetConfig(cfg *config.ResultFilesConfig) {
+	j.cfg.Store(cfg)
+}
+
+type resultFile struct {
+	fname   string
+	size    int64
+	modTime time.Time
+}
+
+// Clean 删除超过保留时间的文件，总大小超过上限时从最早的文件开始删除
+func (j *Janitor) Clean() error {
+	j.cleanMutex.Lock()
+	defer j.cleanMutex.Unlock()
+
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+	files := make([]*resultFile, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &resultFile{
+			fname:   path.Join(j.dir, e.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, k int) bool {
+		return files[i].modTime.Before(files[k].modTime)
+	})
+
+	var totalBytes int64
+	for _, f := range files {
+		totalBytes += f.size
+	}
+
+	cfg := j.cfg.Load()
+	expireTime := time.Now().Add(-cfg.Retention.Duration())
+	maxBytes := cfg.MaxSizeBytes()
+	var removedFiles, removedBytes int64
+	remaining := files[:0]
+	for _, f := range files {
+		expired := f.modTime.Before(expireTime)
+		overQuota := maxBytes > 0 && totalBytes > maxBytes
+		if !expired && !overQuota {
+			remaining = append(remaining, f)
+			continue
+		}
+		if err := os.Remove(f.fname); err != nil && !os.IsNotExist(err) {
+			log.Errorf("remove result file %s err: %v", f.fname, err)
+			remaining = append(remaining, f)
+			continue
+		}
+		totalBytes -= f.size
+		removedFiles++
+		removedBytes += f.size
+	}
+	if removedFiles > 0 {
+		log.Infof("janitor removed %d result files, %d bytes", removedFiles, removedBytes)
+	}
+
+	j.statsMutex.Lock()
+	j.stats.Files = len(remaining)
+	j.stats.Bytes = totalBytes
+	j.stats.RemovedFiles += removedFiles
+	j.stats.RemovedBytes += removedBytes
+	j.stats.LastCleanTime = time.Now()
+	j.statsMutex.Unlock()
+	return nil
+}
+
+// Run 定时清理，直到 Close
+func (j *Janitor) Run() {
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-time.After(j.cfg.Load().CleanInterval.Duration()):
+			if err := j.Clean(); err != nil {
+				log.Errorf("janitor Clean err: %v", err)
+			}
+		}
+	}
+}
+
+func (j *Janitor) Stats() JanitorStats {
+	j.statsMutex.Lock()
+	defer j.statsMutex.Unlock()
+	return j.stats
+}
+
+func (j *Janitor) Close() {
+	j.cancel()
+}
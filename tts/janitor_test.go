@@ -0,0 +1,49 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, size int, age time.Duration) {
+		fname := path.Join(dir, name)
+		if err := os.WriteFile(fname, make([]byte, size), 0644); err != nil {
+			t.Fatalf("WriteFile err: %v", err)
+		}
+		modTime := time.Now().Add(-age)
+		os.Chtimes(fname, modTime, modTime)
+	}
+	writeFile("expired.wav", 10, 2*time.Hour)
+	writeFile("old.wav", 600*1024, 30*time.Minute)
+	writeFile("new.wav", 600*1024, time.Minute)
+	// 子目录中的缓存不会被清理
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "cache"), os.ModePerm))
+
+	j := NewJanitor(dir, &config.ResultFilesConfig{
+		Retention: config.Duration(time.Hour),
+		MaxSizeMB: 1,
+	})
+	defer j.Close()
+	assert.NoError(t, j.Clean())
+
+	_, err := os.Stat(path.Join(dir, "expired.wav"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, "old.wav"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, "new.wav"))
+	assert.NoError(t, err)
+	_, err = os.Stat(path.Join(dir, "cache"))
+	assert.NoError(t, err)
+
+	stats := j.Stats()
+	assert.Equal(t, 1, stats.Files)
+	assert.Equal(t, int64(600*1024), stats.Bytes)
+	assert.Equal(t, int64(2), stats.RemovedFiles)
+	assert.Equal(t, int64(600*1024+10), stats.RemovedBytes)
+}
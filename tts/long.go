@@ -0,0 +1,128 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// 分段时优先在句末标点处断开，其次是逗号等停顿
+var (
+	sentenceEnds = "。！？!?；;\n"
+	clauseEnds   = "，,、：: "
+)
+
+// splitText 按句子把文本分成不超过 maxLen 个字的段落，所有段落拼接后和原文相同
+func splitText(text string, maxLen int) []string {
+	var segments []string
+	runes := []rune(text)
+	for len(runes) > maxLen {
+		cut := lastIndexAny(runes[:maxLen], sentenceEnds)
+		if cut < 0 {
+			cut = lastIndexAny(runes[:maxLen], clauseEnds)
+		}
+		if cut < 0 {
+			cut = maxLen - 1
+		}
+		segments = append(segments, string(runes[:cut+1]))
+		runes = runes[cut+1:]
+	}
+	if len(runes) > 0 {
+		segments = append(segments, string(runes))
+	}
+	return segments
+}
+
+func lastIndexAny(runes []rune, chars string) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if strings.ContainsRune(chars, runes[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SplitSynthesizer 文本超过 threshold 个字时按句子分段合成，拼接成一个音频
+// 分段合成时全部完成后才一次写入 w，SSML 不分段
+type SplitSynthesizer struct {
+	Synthesizer
+	threshold int
+}
+
+func NewSplitSynthesizer(s Synthesizer, threshold int) *SplitSynthesizer {
+	return &SplitSynthesizer{Synthesizer: s, threshold: threshold}
+}
+
+func (s *SplitSynthesizer) Statuses() []*BreakerStatus {
+	return synthesizerStatuses(s.Synthesizer)
+}
+
+func (s *SplitSynthesizer) Probe(ctx context.Context) {
+	probeSynthesizer(ctx, s.Synthesizer)
+}
+
+func (s *SplitSynthesizer) breakers() []*ResilientSynthesizer {
+	return synthesizerBreakers(s.Synthesizer)
+}
+
+func (s *SplitSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	if IsSSML(req.Text) || utf8.RuneCountInString(req.Text) <= s.threshold {
+		return s.Synthesizer.Synthesize(ctx, req, w)
+	}
+
+	var (
+		segments    [][]byte
+		subtitles   []*Subtitle
+		offsetTime  int
+		offsetIndex int
+	)
+	for i, text := range splitText(req.Text, s.threshold) {
+		textLen := utf8.RuneCountInString(text)
+		if strings.TrimSpace(text) == "" {
+			offsetIndex += textLen
+			continue
+		}
+
+		segReq := *req
+		segReq.Text = text
+		var segSubtitles []*Subtitle
+		if req.OnSubtitles != nil {
+			segReq.OnSubtitles = func(subs []*Subtitle) {
+				segSubtitles = append(segSubtitles, subs...)
+			}
+		}
+		var buf bytes.Buffer
+		if err := s.Synthesizer.Synthesize(ctx, &segReq, &buf); err != nil {
+			return fmt.Errorf("synthesize segment %d err: %w", i, err)
+		}
+
+		// 字幕的时间和位置加上前面段落的长度
+		for _, sub := range segSubtitles {
+			sub.BeginTime += offsetTime
+			sub.EndTime += offsetTime
+			sub.BeginIndex += offsetIndex
+			sub.EndIndex += offsetIndex
+		}
+		subtitles = append(subtitles, segSubtitles...)
+		if d, ok := audioDuration(req.Format, req.SampleRate, buf.Bytes()); ok {
+			offsetTime += int(d.Milliseconds())
+		} else if len(segSubtitles) > 0 {
+			offsetTime = segSubtitles[len(segSubtitles)-1].EndTime
+		}
+		offsetIndex += textLen
+		segments = append(segments, buf.Bytes())
+	}
+
+	data, err := concatAudio(req.Format, segments)
+	if err != nil {
+		return fmt.Errorf("concat audio err: %w", err)
+	}
+	if req.OnSubtitles != nil && len(subtitles) > 0 {
+		req.OnSubtitles(subtitles)
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,109 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		want   []string
+	}{
+		{"short", "你好。", 10, []string{"你好。"}},
+		{"sentence", "第一句。第二句！第三句", 6, []string{"第一句。", "第二句！", "第三句"}},
+		{"clause", "一二三，四五六七八", 6, []string{"一二三，", "四五六七八"}},
+		{"hard cut", "一二三四五六七八", 3, []string{"一二三", "四五六", "七八"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := splitText(tt.text, tt.maxLen)
+			assert.Equal(t, tt.want, segments)
+			assert.Equal(t, tt.text, strings.Join(segments, ""))
+		})
+	}
+}
+
+// wavSynthesizer 每个字生成 100ms 的 16 位单声道 WAV，data 块长度为0，和流式合成一样
+type wavSynthesizer struct {
+	texts []string
+	mutex sync.Mutex
+}
+
+func (s *wavSynthesizer) Name() string { return "wav" }
+
+func (s *wavSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	s.mutex.Lock()
+	s.texts = append(s.texts, req.Text)
+	s.mutex.Unlock()
+	n := utf8.RuneCountInString(req.Text)
+	if req.OnSubtitles != nil {
+		req.OnSubtitles([]*Subtitle{{
+			Text:      req.Text,
+			Sentence:  true,
+			EndIndex:  n,
+			BeginTime: 0,
+			EndTime:   n * 100,
+		}})
+	}
+	f := &wavFormat{
+		AudioFormat:   1,
+		Channels:      1,
+		SampleRate:    uint32(req.SampleRate),
+		ByteRate:      uint32(req.SampleRate * 2),
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	}
+	data := encodeWAV(f, make([]byte, req.SampleRate*2/10*n))
+	binary.LittleEndian.PutUint32(data[40:44], 0)
+	_, err := w.Write(data)
+	return err
+}
+
+func TestSplitSynthesizer(t *testing.T) {
+	ws := &wavSynthesizer{}
+	s := NewSplitSynthesizer(ws, 4)
+
+	var subtitles []*Subtitle
+	var buf bytes.Buffer
+	req := &SynthesisRequest{
+		Text:       "第一句。第二句。",
+		Format:     "wav",
+		SampleRate: 16000,
+		OnSubtitles: func(subs []*Subtitle) {
+			subtitles = append(subtitles, subs...)
+		},
+	}
+	assert.NoError(t, s.Synthesize(context.Background(), req, &buf))
+	assert.Equal(t, []string{"第一句。", "第二句。"}, ws.texts)
+
+	f, pcm, err := parseWAV(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 800*time.Millisecond, f.duration(len(pcm)))
+	assert.EqualValues(t, len(pcm), binary.LittleEndian.Uint32(buf.Bytes()[40:44]))
+	assert.EqualValues(t, buf.Len()-8, binary.LittleEndian.Uint32(buf.Bytes()[4:8]))
+
+	if assert.Len(t, subtitles, 2) {
+		assert.Equal(t, 4, subtitles[1].BeginIndex)
+		assert.Equal(t, 8, subtitles[1].EndIndex)
+		assert.Equal(t, 400, subtitles[1].BeginTime)
+		assert.Equal(t, 800, subtitles[1].EndTime)
+	}
+
+	// 短文本和 SSML 不分段
+	ws.texts = nil
+	buf.Reset()
+	assert.NoError(t, s.Synthesize(context.Background(), &SynthesisRequest{Text: "短文本", Format: "wav", SampleRate: 16000}, &buf))
+	assert.NoError(t, s.Synthesize(context.Background(), &SynthesisRequest{Text: "<speak>第一句。第二句。</speak>", Format: "wav", SampleRate: 16000}, &buf))
+	assert.Equal(t, []string{"短文本", "<speak>第一句。第二句。</speak>"}, ws.texts)
+}
@@ -0,0 +1,228 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const numberMaxValueDigits = 8 // 超过这个位数的数字逐位读，例如 UID 和手机号
+
+var (
+	urlRegexp        = regexp.MustCompile(`(?i)(?:https?://|www\.)[a-z0-9\-._~:/?#@!$&'()*+,;=%]+`)
+	emoteRegexp      = regexp.MustCompile(`\[([^\[\]\s]{1,16})\]`)
+	numberRegexp     = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+	digitNames = []rune("零一二三四五六七八九")
+)
+
+// Normalizer 合成前整理文本，去掉读出来没有意义或者太长的内容
+type Normalizer struct {
+	cfg *config.TTSNormalizeConfig
+}
+
+func NewNormalizer(cfg *config.TTSNormalizeConfig) *Normalizer {
+	return &Normalizer{cfg: cfg}
+}
+
+// Normalize 整理文本，uname 不为空时缩短文本中的用户名，SSML 只整理标签之间的文本
+func (n *Normalizer) Normalize(text, uname string) string {
+	if IsSSML(text) {
+		text = replaceSSMLText(text, func(s string) string {
+			return n.normalize(s, uname)
+		})
+		text = strings.TrimSpace(whitespaceRegexp.ReplaceAllString(text, " "))
+		if PlainText(text) == "" {
+			return ""
+		}
+		return text
+	}
+	return strings.TrimSpace(whitespaceRegexp.ReplaceAllString(n.normalize(text, uname), " "))
+}
+
+func (n *Normalizer) normalize(text, uname string) string {
+	if uname != "" {
+		if name := n.NormalizeName(uname); name != uname {
+			text = strings.ReplaceAll(text, uname, name)
+		}
+	}
+
+	switch n.cfg.URL {
+	case config.NormalizeModeStrip:
+		text = urlRegexp.ReplaceAllString(text, " ")
+	case config.NormalizeModeSpeak:
+		text = urlRegexp.ReplaceAllStringFunc(text, speakURL)
+	}
+
+	switch n.cfg.Emote {
+	case config.NormalizeModeStrip:
+		text = emoteRegexp.ReplaceAllString(text, "")
+	case config.NormalizeModeSpeak:
+		text = emoteRegexp.ReplaceAllString(text, "$1")
+	}
+
+	if n.cfg.CollapseRepeat > 0 {
+		text = collapseRepeat(text, n.cfg.CollapseRepeat)
+	}
+	if n.cfg.VerbalizeNumbers {
+		text = verbalizeNumbers(text, n.cfg.CollapseRepeat)
+	}
+	return text
+}
+
+// NormalizeName 删除用户名中的数字和符号并截断，删除后为空时保留原来的用户名
+func (n *Normalizer) NormalizeName(name string) string {
+	if n.cfg.StripNameSymbols {
+		stripped := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) {
+				return r
+			}
+			return -1
+		}, name)
+		if stripped != "" {
+			name = stripped
+		}
+	}
+	if max := n.cfg.MaxNameLength; max > 0 {
+		if runes := []rune(name); len(runes) > max {
+			name = string(runes[:max])
+		}
+	}
+	return name
+}
+
+func speakURL(s string) string {
+	raw := s
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return " 链接 "
+	}
+	return " " + strings.TrimPrefix(u.Hostname(), "www.") + " 的链接 "
+}
+
+// collapseRepeat 连续重复的字符最多保留 max 个，数字由 verbalizeNumbers 处理
+func collapseRepeat(text string, max int) string {
+	var b strings.Builder
+	var last rune
+	count := 0
+	for _, r := range text {
+		if r == last && !unicode.IsDigit(r) {
+			count++
+		} else {
+			last = r
+			count = 1
+		}
+		if count <= max {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// verbalizeNumbers 数字转换成中文读法，重复的数字和很长的数字逐位读
+func verbalizeNumbers(text string, maxRepeat int) string {
+	locs := numberRegexp.FindAllStringIndex(text, -1)
+	if len(locs) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(readNumber(text[loc[0]:loc[1]], strings.HasPrefix(text[loc[1]:], "年"), maxRepeat))
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func readNumber(s string, isYear bool, maxRepeat int) string {
+	intPart, fracPart, hasFrac := strings.Cut(s, ".")
+	if hasFrac {
+		return readNumber(intPart, false, maxRepeat) + "点" + readDigits(fracPart)
+	}
+	if isYear && len(s) == 4 {
+		return readDigits(s)
+	}
+	if len(s) >= 3 && strings.Count(s, s[:1]) == len(s) {
+		// 666666
+		if maxRepeat > 0 && len(s) > maxRepeat {
+			s = s[:maxRepeat]
+		}
+		return readDigits(s)
+	}
+	if len(s) > numberMaxValueDigits || (len(s) > 1 && s[0] == '0') {
+		return readDigits(s)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return readDigits(s)
+	}
+	return readInt(n)
+}
+
+func readDigits(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		b.WriteRune(digitNames[c-'0'])
+	}
+	return b.String()
+}
+
+// readInt 按数值读，n 小于一万亿
+func readInt(n int) string {
+	if n == 0 {
+		return "零"
+	}
+	var sections []int
+	for ; n > 0; n /= 10000 {
+		sections = append(sections, n%10000)
+	}
+	sectionUnits := []string{"", "万", "亿"}
+
+	var b strings.Builder
+	for i := len(sections) - 1; i >= 0; i-- {
+		section := sections[i]
+		if section == 0 {
+			continue
+		}
+		if b.Len() > 0 && section < 1000 {
+			b.WriteRune('零')
+		}
+		b.WriteString(readSection(section))
+		b.WriteString(sectionUnits[i])
+	}
+	// 10 到 19 读作 "十X"
+	if s := b.String(); strings.HasPrefix(s, "一十") {
+		return strings.TrimPrefix(s, "一")
+	}
+	return b.String()
+}
+
+func readSection(n int) string {
+	units := []string{"千", "百", "十", ""}
+	digits := []int{n / 1000, n / 100 % 10, n / 10 % 10, n % 10}
+	var b strings.Builder
+	started, zero := false, false
+	for i, d := range digits {
+		if d == 0 {
+			zero = started
+			continue
+		}
+		if zero {
+			b.WriteRune('零')
+			zero = false
+		}
+		b.WriteRune(digitNames[d])
+		b.WriteString(units[i])
+		started = true
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	defaultCfg := config.DefaultTTSNormalizeConfig
+	disabledCfg := config.TTSNormalizeConfig{}
+	stripURLSpeakEmoteCfg := config.DefaultTTSNormalizeConfig
+	stripURLSpeakEmoteCfg.URL = config.NormalizeModeStrip
+	stripURLSpeakEmoteCfg.Emote = config.NormalizeModeSpeak
+
+	tests := []struct {
+		name  string
+		cfg   *config.TTSNormalizeConfig
+		text  string
+		uname string
+		want  string
+	}{
+		{"collapse repeat", &defaultCfg, "哈哈哈哈哈哈哈哈", "", "哈哈哈"},
+		{"collapse punctuation", &defaultCfg, "好耶！！！！！！", "", "好耶！！！"},
+		{"repeated digits", &defaultCfg, "666666", "", "六六六"},
+		{"number value", &defaultCfg, "送了520个", "", "送了五百二十个"},
+		{"number ten", &defaultCfg, "15级", "", "十五级"},
+		{"number zero", &defaultCfg, "1005", "", "一千零五"},
+		{"number wan", &defaultCfg, "120000", "", "十二万"},
+		{"number wan zero", &defaultCfg, "10005", "", "一万零五"},
+		{"number decimal", &defaultCfg, "3.14", "", "三点一四"},
+		{"number year", &defaultCfg, "2024年", "", "二零二四年"},
+		{"long number", &defaultCfg, "UID 123456789", "", "UID 一二三四五六七八九"},
+		{"leading zero", &defaultCfg, "007", "", "零零七"},
+		{"speak url", &defaultCfg, "看 https://www.bilibili.com/video/BV1xx 这个", "", "看 bilibili.com 的链接 这个"},
+		{"strip url", &stripURLSpeakEmoteCfg, "看 https://www.bilibili.com/video/BV1xx 这个", "", "看 这个"},
+		{"strip emote", &defaultCfg, "好耶[dog][妙啊]", "", "好耶"},
+		{"speak emote", &stripURLSpeakEmoteCfg, "好耶[妙啊]", "", "好耶妙啊"},
+		{"strip name symbols", &defaultCfg, "小明_123说：你好", "小明_123", "小明说：你好"},
+		{"trim long name", &defaultCfg, "一二三四五六七八九十甲乙丙丁说：你好", "一二三四五六七八九十甲乙丙丁", "一二三四五六七八九十甲乙说：你好"},
+		{"keep symbol name", &defaultCfg, "123说：你好", "123", "一百二十三说：你好"},
+		{"only emote", &defaultCfg, "[dog]", "", ""},
+		{"ssml", &defaultCfg, `<speak>小明_123说<break time="500ms"/>666666&amp;[dog]</speak>`, "小明_123", `<speak>小明说<break time="500ms"/>六六六&amp;</speak>`},
+		{"empty ssml", &defaultCfg, `<speak><break time="500ms"/>[dog]</speak>`, "", ""},
+		{"disabled", &disabledCfg, "哈哈哈哈 666666 [dog]", "", "哈哈哈哈 666666 [dog]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NewNormalizer(tt.cfg).Normalize(tt.text, tt.uname))
+		})
+	}
+}
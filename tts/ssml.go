@@ -0,0 +1,38 @@
+package tts
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	ssmlTagRegexp   = regexp.MustCompile(`<[^>]*>`)
+	ssmlTokenRegexp = regexp.MustCompile(`<[^>]*>|&#?[0-9a-zA-Z]+;`)
+)
+
+// IsSSML 和阿里云一样，以 <speak> 开头的文本按 SSML 合成
+func IsSSML(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "<speak")
+}
+
+// PlainText 去掉 SSML 标签，用于显示
+func PlainText(text string) string {
+	if !IsSSML(text) {
+		return text
+	}
+	return strings.TrimSpace(html.UnescapeString(ssmlTagRegexp.ReplaceAllString(text, "")))
+}
+
+// replaceSSMLText 只替换标签和转义字符之间的文本
+func replaceSSMLText(text string, f func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range ssmlTokenRegexp.FindAllStringIndex(text, -1) {
+		b.WriteString(f(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(f(text[last:]))
+	return b.String()
+}
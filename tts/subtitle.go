@@ -0,0 +1,67 @@
+package tts
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Subtitle 字幕时间轴，sentence 为 true 时是整句，否则是单个字词，时间单位为毫秒
+type Subtitle struct {
+	Text       string `json:"text"`
+	Sentence   bool   `json:"sentence"`
+	BeginIndex int    `json:"begin_index"` // 在合成文本中的位置，按字计算
+	EndIndex   int    `json:"end_index"`
+	BeginTime  int    `json:"begin_time"`
+	EndTime    int    `json:"end_time"`
+}
+
+// attemptRequest 单次合成尝试使用的请求，字幕先缓存，成功后调用 commit 转发给 req.OnSubtitles
+// 重试或者换后端时丢弃失败尝试的字幕，避免时间轴重复
+func attemptRequest(req *SynthesisRequest) (*SynthesisRequest, func()) {
+	if req.OnSubtitles == nil {
+		return req, func() {}
+	}
+	var (
+		subtitles []*Subtitle
+		mutex     sync.Mutex
+	)
+	r := *req
+	r.OnSubtitles = func(subs []*Subtitle) {
+		mutex.Lock()
+		subtitles = append(subtitles, subs...)
+		mutex.Unlock()
+	}
+	return &r, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(subtitles) > 0 {
+			req.OnSubtitles(subtitles)
+		}
+	}
+}
+
+// subtitleCacheKey 字幕和音频分开缓存
+func subtitleCacheKey(key string) string {
+	return key + ".subtitle"
+}
+
+func readSubtitles(fname string) ([]*Subtitle, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var subtitles []*Subtitle
+	if err := json.Unmarshal(data, &subtitles); err != nil {
+		return nil, err
+	}
+	return subtitles, nil
+}
+
+func writeSubtitles(fname string, subtitles []*Subtitle) error {
+	data, err := json.Marshal(subtitles)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, data, 0666)
+}
@@ -0,0 +1,144 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// SynthesisRequest 合成参数
+type SynthesisRequest struct {
+	Text       string `json:"text"` // 以 <speak> 开头时为 SSML
+	Voice      string `json:"voice"`
+	Format     string `json:"format"`
+	SampleRate int    `json:"sample_rate"`
+	Volume     int    `json:"volume"`
+	SpeechRate int    `json:"speech_rate"`
+	PitchRate  int    `json:"pitch_rate"`
+
+	// OnSubtitles 合成后端支持字幕时调用，可能调用多次，为 nil 时不需要字幕
+	OnSubtitles func(subtitles []*Subtitle) `json:"-"`
+	// OnFallback 前面的后端失败、由备用后端 backend 合成成功时调用，可以为 nil
+	OnFallback func(backend string) `json:"-"`
+}
+
+// CacheKey 相同参数的合成结果可以复用，不包括合成后端，备用后端的结果不应该写入缓存
+func (req *SynthesisRequest) CacheKey() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d\x00%d\x00%d",
+		req.Text, req.Voice, req.Format, req.SampleRate, req.Volume, req.SpeechRate, req.PitchRate)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Synthesizer TTS合成后端
+type Synthesizer interface {
+	Name() string
+	// Synthesize 合成语音，音频数据写入 w，超时由实现控制
+	Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error
+}
+
+// FallbackSynthesizer 按顺序尝试合成，前一个失败时使用下一个
+type FallbackSynthesizer []Synthesizer
+
+func (f FallbackSynthesizer) Name() string {
+	names := make([]string, len(f))
+	for i, s := range f {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Synthesize 音频数据直接写入 w，已经写入部分数据的后端失败时不再尝试下一个
+func (f FallbackSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	var errs []error
+	for i, s := range f {
+		cw := &countingWriter{w: w}
+		attemptReq, commit := attemptRequest(req)
+		err := s.Synthesize(ctx, attemptReq, cw)
+		if err == nil {
+			commit()
+			if i > 0 && req.OnFallback != nil {
+				req.OnFallback(s.Name())
+			}
+			return nil
+		}
+		log.Warnf("synthesizer %s failed: %v", s.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		if ctx.Err() != nil || cw.n > 0 {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return errors.New("no synthesizer")
+	}
+	return errors.Join(errs...)
+}
+
+func (f FallbackSynthesizer) Statuses() []*BreakerStatus {
+	var statuses []*BreakerStatus
+	for _, s := range f {
+		statuses = append(statuses, synthesizerStatuses(s)...)
+	}
+	return statuses
+}
+
+func (f FallbackSynthesizer) Probe(ctx context.Context) {
+	for _, s := range f {
+		probeSynthesizer(ctx, s)
+	}
+}
+
+func (f FallbackSynthesizer) breakers() []*ResilientSynthesizer {
+	var breakers []*ResilientSynthesizer
+	for _, s := range f {
+		breakers = append(breakers, synthesizerBreakers(s)...)
+	}
+	return breakers
+}
+
+// NewSynthesizer 根据配置创建合成后端，阿里云后端共享 tokens，每个后端单独重试和熔断，onChange 在熔断和恢复时调用
+func NewSynthesizer(cfg *config.Config, tokens *TokenManager, onChange func(status *BreakerStatus)) (Synthesizer, error) {
+	var chain FallbackSynthesizer
+	for _, name := range cfg.TTS.Backends {
+		var backend Synthesizer
+		if name == config.TTSBackendAliyun {
+			longTextThreshold := 0
+			if cfg.TTS.LongText == config.LongTextAliyunLong {
+				longTextThreshold = cfg.TTS.LongTextThreshold
+			}
+			backend = NewAliyunSynthesizer(cfg.AliyunTTS, tokens, longTextThreshold)
+		} else {
+			b, ok := cfg.TTS.HTTPBackends[name]
+			if !ok {
+				return nil, fmt.Errorf("tts backend %s not found", name)
+			}
+			backend = NewHTTPSynthesizer(name, b)
+		}
+		chain = append(chain, NewResilientSynthesizer(backend, cfg.TTSRetry, onChange))
+	}
+	var s Synthesizer = chain
+	if len(chain) == 1 {
+		s = chain[0]
+	}
+	if cfg.TTS.LongText == config.LongTextSplit {
+		s = NewSplitSynthesizer(s, cfg.TTS.LongTextThreshold)
+	}
+	return s, nil
+}
@@ -0,0 +1,124 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type failSynthesizer struct{}
+
+func (failSynthesizer) Name() string { return "fail" }
+
+func (failSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	return errors.New("unavailable")
+}
+
+// partialSynthesizer 写入部分数据后失败
+type partialSynthesizer struct{}
+
+func (partialSynthesizer) Name() string { return "partial" }
+
+func (partialSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	w.Write([]byte("partial"))
+	return errors.New("connection reset")
+}
+
+func TestFallbackSynthesizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SynthesisRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		assert.Equal(t, "token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write([]byte("wav:" + req.Text))
+	}))
+	defer srv.Close()
+
+	s := FallbackSynthesizer{
+		failSynthesizer{},
+		NewHTTPSynthesizer("local", &config.HTTPTTSConfig{
+			URL:     srv.URL,
+			Headers: map[string]string{"Authorization": "token"},
+			Timeout: config.Duration(time.Second),
+		}),
+	}
+	assert.Equal(t, "fail,local", s.Name())
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.Synthesize(context.Background(), &SynthesisRequest{Text: "hello"}, &buf))
+	assert.Equal(t, "wav:hello", buf.String())
+
+	buf.Reset()
+	err := FallbackSynthesizer{failSynthesizer{}}.Synthesize(context.Background(), &SynthesisRequest{Text: "hello"}, &buf)
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+
+	// 已经写入部分数据时不再尝试下一个
+	buf.Reset()
+	err = FallbackSynthesizer{partialSynthesizer{}, s}.Synthesize(context.Background(), &SynthesisRequest{Text: "hello"}, &buf)
+	assert.Error(t, err)
+	assert.Equal(t, "partial", buf.String())
+}
+
+// recoverSynthesizer failing 为 true 时失败，否则写入 name 和文本
+type recoverSynthesizer struct {
+	name    string
+	failing atomic.Bool
+}
+
+func (s *recoverSynthesizer) Name() string { return s.name }
+
+func (s *recoverSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	if s.failing.Load() {
+		return errors.New("unavailable")
+	}
+	_, err := w.Write([]byte(s.name + ":" + req.Text))
+	return err
+}
+
+func TestTTSCacheFallback(t *testing.T) {
+	primary := &recoverSynthesizer{name: "primary"}
+	tts := newTestTTS(t, FallbackSynthesizer{primary, &recoverSynthesizer{name: "fallback"}})
+
+	run := func() (*Task, string) {
+		task, err := tts.NewTask(&NewTaskParams{Text: "hello"})
+		if err != nil {
+			t.Fatalf("NewTask err: %v", err)
+		}
+		fname, err := task.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run err: %v", err)
+		}
+		data, _ := os.ReadFile(fname)
+		return task, string(data)
+	}
+
+	// 主后端失败一次，备用后端的结果不写入缓存
+	primary.failing.Store(true)
+	task, audio := run()
+	assert.False(t, task.cached)
+	assert.Equal(t, "fallback:hello", audio)
+
+	// 主后端恢复后重新合成并写入缓存
+	primary.failing.Store(false)
+	task, audio = run()
+	assert.False(t, task.cached)
+	assert.Equal(t, "primary:hello", audio)
+
+	task, audio = run()
+	assert.True(t, task.cached)
+	assert.Equal(t, "primary:hello", audio)
+}
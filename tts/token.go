@@ -0,0 +1,131 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	nls "blive-vup-layer/tts/alibabacloud-nls-go-sdk"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tokenRefreshBefore = 10 * time.Minute // 过期前多久在后台刷新
+	tokenExpireMargin  = time.Minute      // 剩余时间少于这个值时不再使用
+	tokenCheckInterval = time.Minute
+)
+
+func fetchAliyunToken(cfg *config.AliyunTTSConfig) (*nls.TokenResultMessage, error) {
+	msg, err := nls.GetToken(nls.DEFAULT_DISTRIBUTE, nls.DEFAULT_DOMAIN, cfg.AccessKey, cfg.SecretKey, nls.DEFAULT_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	if msg.TokenResult.Id == "" {
+		return nil, fmt.Errorf("obtain empty token err: %s", msg.ErrMsg)
+	}
+	return msg, nil
+}
+
+// TokenManager 缓存阿里云 NLS token，所有任务共享，过期前在后台刷新
+type TokenManager struct {
+	cfg   atomic.Pointer[config.AliyunTTSConfig]
+	fetch func(cfg *config.AliyunTTSConfig) (*nls.TokenResultMessage, error)
+
+	token    *nls.TokenResultMessage
+	tokenCfg *config.AliyunTTSConfig // 获取 token 时使用的配置
+	err      error
+	mutex    sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewTokenManager(cfg *config.AliyunTTSConfig) *TokenManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &TokenManager{
+		fetch:  fetchAliyunToken,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	m.SetConfig(cfg)
+	return m
+}
+
+// SetConfig 替换配置，AccessKey 变化后下次使用时重新获取 token
+func (m *TokenManager) SetConfig(cfg *config.AliyunTTSConfig) {
+	m.cfg.Store(cfg)
+}
+
+func expireTime(token *nls.TokenResultMessage) time.Time {
+	return time.Unix(token.TokenResult.ExpireTime, 0)
+}
+
+// Token 返回缓存的 token，没有或者即将过期时同步获取
+func (m *TokenManager) Token() (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cfg := m.cfg.Load()
+	if m.token != nil && m.sameKey(cfg) && time.Until(expireTime(m.token)) > tokenExpireMargin {
+		return m.token.TokenResult.Id, nil
+	}
+	if err := m.refresh(cfg); err != nil {
+		return "", err
+	}
+	return m.token.TokenResult.Id, nil
+}
+
+func (m *TokenManager) sameKey(cfg *config.AliyunTTSConfig) bool {
+	return m.tokenCfg != nil &&
+		m.tokenCfg.AccessKey == cfg.AccessKey &&
+		m.tokenCfg.SecretKey == cfg.SecretKey
+}
+
+// refresh 获取新的 token，需要持有 mutex
+func (m *TokenManager) refresh(cfg *config.AliyunTTSConfig) error {
+	token, err := m.fetch(cfg)
+	if err != nil {
+		log.Errorf("get aliyun token err: %v", err)
+		m.err = err
+		if m.token != nil && !m.sameKey(cfg) {
+			m.token = nil
+		}
+		return err
+	}
+	log.Infof("get aliyun token, expire time: %s", expireTime(token))
+	m.token = token
+	m.tokenCfg = cfg
+	m.err = nil
+	return nil
+}
+
+// Err 最近一次获取 token 的错误，成功后清空，用于健康检查
+func (m *TokenManager) Err() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+// Run 在 token 过期前刷新，只刷新已经使用过的 token，直到 Close
+func (m *TokenManager) Run() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(tokenCheckInterval):
+		}
+
+		m.mutex.Lock()
+		cfg := m.cfg.Load()
+		if m.token != nil && (!m.sameKey(cfg) || time.Until(expireTime(m.token)) < tokenRefreshBefore) {
+			m.refresh(cfg)
+		}
+		m.mutex.Unlock()
+	}
+}
+
+func (m *TokenManager) Close() {
+	m.cancel()
+}
@@ -0,0 +1,73 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	nls "blive-vup-layer/tts/alibabacloud-nls-go-sdk"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenManager(t *testing.T) {
+	var calls atomic.Int32
+	var fail atomic.Bool
+	expire := time.Now().Add(time.Hour)
+	m := NewTokenManager(&config.AliyunTTSConfig{AccessKey: "ak", SecretKey: "sk"})
+	defer m.Close()
+	m.fetch = func(cfg *config.AliyunTTSConfig) (*nls.TokenResultMessage, error) {
+		n := calls.Add(1)
+		if fail.Load() {
+			return nil, errors.New("forbidden")
+		}
+		msg := &nls.TokenResultMessage{}
+		msg.TokenResult.Id = fmt.Sprintf("%s-%d", cfg.AccessKey, n)
+		msg.TokenResult.ExpireTime = expire.Unix()
+		return msg, nil
+	}
+
+	// 并发获取只请求一次
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := m.Token()
+			assert.NoError(t, err)
+			assert.Equal(t, "ak-1", token)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, calls.Load())
+	assert.NoError(t, m.Err())
+
+	// 即将过期时重新获取
+	expire = time.Now().Add(30 * time.Second)
+	m.token.TokenResult.ExpireTime = expire.Unix()
+	token, err := m.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "ak-2", token)
+
+	// AccessKey 变化后重新获取
+	expire = time.Now().Add(time.Hour)
+	m.SetConfig(&config.AliyunTTSConfig{AccessKey: "ak2", SecretKey: "sk"})
+	token, err = m.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "ak2-3", token)
+
+	// 获取失败时记录错误，成功后清空
+	fail.Store(true)
+	m.SetConfig(&config.AliyunTTSConfig{AccessKey: "ak3", SecretKey: "sk"})
+	_, err = m.Token()
+	assert.Error(t, err)
+	assert.Error(t, m.Err())
+
+	fail.Store(false)
+	token, err = m.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "ak3-5", token)
+	assert.NoError(t, m.Err())
+}
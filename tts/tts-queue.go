@@ -1,83 +1,566 @@
 package tts
 
 import (
+	"blive-vup-layer/config"
 	"context"
-	"fmt"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Priority TTS任务优先级，数值越大越先合成
+type Priority int
+
+const (
+	PriorityWelcome   Priority = iota // 进入直播间欢迎
+	PriorityDanmu                     // 弹幕
+	PriorityLLM                       // 大模型回复
+	PriorityGift                      // 礼物
+	PrioritySuperChat                 // 醒目留言、大航海和开播下播等系统消息
+)
+
+const (
+	DropReasonExpired = "expired" // 等待时间超过 max_age
+	DropReasonBacklog = "backlog" // 积压超过 max_backlog
+	DropReasonEmpty   = "empty"   // 整理后没有需要合成的文本
+)
+
+var ErrQueueClosed = errors.New("tts queue closed")
+
+// pausedCheckInterval 所有合成后端熔断时暂停合成，每隔这个时间检查一次是否恢复
+const pausedCheckInterval = time.Second
+
+type queueItem struct {
+	params   *NewTaskParams
+	pushTime time.Time
+}
+
+// StreamHandler 接收合成过程中的音频数据，同一个任务按 OnStart、OnChunk、OnEnd 的顺序调用
+// 不同任务按返回结果的顺序依次调用，上一个任务 OnEnd 并且播放确认之后才会调用下一个任务的 OnStart
+type StreamHandler interface {
+	OnStart(taskId string, req *SynthesisRequest)
+	OnChunk(taskId string, data []byte)
+	OnEnd(r *TaskResult)
+}
+
+// streamRelay 按返回结果的顺序推送合成中的音频
+// 轮到任务播放之前缓存已经合成的音频，轮到时推送 OnStart 和缓存的音频，之后的音频直接推送
+type streamRelay struct {
+	handler StreamHandler
+	taskId  string
+	req     *SynthesisRequest
+	chunks  [][]byte
+	live    bool // 已经轮到这个任务播放
+	started bool // 已经推送 OnStart
+	mutex   sync.Mutex
+}
+
+// start 任务开始合成，已经轮到这个任务时直接推送 OnStart
+func (r *streamRelay) start(taskId string, req *SynthesisRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.taskId = taskId
+	r.req = req
+	if r.live {
+		r.handler.OnStart(taskId, req)
+		r.started = true
+	}
+}
+
+func (r *streamRelay) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.started {
+		r.handler.OnChunk(r.taskId, p)
+	} else {
+		r.chunks = append(r.chunks, append([]byte(nil), p...))
+	}
+	return len(p), nil
+}
+
+// release 轮到这个任务播放，推送缓存的音频
+func (r *streamRelay) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.live = true
+	if r.taskId == "" {
+		return
+	}
+	r.handler.OnStart(r.taskId, r.req)
+	r.started = true
+	for _, chunk := range r.chunks {
+		r.handler.OnChunk(r.taskId, chunk)
+	}
+	r.chunks = nil
+}
+
+// end 推送合成结果，没有推送过 OnStart 的任务不推送
+func (r *streamRelay) end(res *TaskResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.started {
+		r.handler.OnEnd(res)
+	}
+}
+
+// TTSQueue 按优先级合成TTS，Push 不会阻塞
+// 最多取出 workers 个还没有返回结果的任务同时合成，之后新来的高优先级任务最多排在 workers 个任务之后
 type TTSQueue struct {
-	tts *TTS
-	ch  chan *TaskWithChannel
+	tts    *TTS
+	cfg    atomic.Pointer[config.TTSQueueConfig]
+	stream StreamHandler
+
+	items      []*queueItem
+	dropped    []*TaskResult
+	itemsMutex sync.Mutex
+	notify     chan struct{}
+
+	inFlight   atomic.Int32 // 正在合成的任务数量
+	unreleased atomic.Int32 // 已经取出、还没有返回结果的任务数量，包括正在合成的任务
+	released   chan struct{}
+	wg         sync.WaitGroup
+
+	playing      *Playback
+	playingMutex sync.Mutex
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewTTSQueue(tts *TTS) *TTSQueue {
+// QueueStats 队列状态
+type QueueStats struct {
+	Depth    int `json:"depth"`     // 等待合成的任务数量
+	InFlight int `json:"in_flight"` // 正在合成的任务数量
+	Workers  int `json:"workers"`
+
+	Playing *Playback `json:"playing,omitempty"` // 正在播放的音频
+}
+
+// Playback 已经推送给前端、还没有收到播放确认的音频
+type Playback struct {
+	TaskId    string    `json:"task_id"`
+	Text      string    `json:"text"`
+	Duration  int64     `json:"duration"` // 音频时长，单位毫秒，无法计算时为0
+	StartTime time.Time `json:"start_time"`
+
+	done chan struct{}
+}
+
+func NewTTSQueue(tts *TTS, cfg *config.TTSQueueConfig) *TTSQueue {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &TTSQueue{
-		tts: tts,
-		ch:  make(chan *TaskWithChannel, 64),
+	q := &TTSQueue{
+		tts:      tts,
+		notify:   make(chan struct{}, 1),
+		released: make(chan struct{}, 1),
 
 		ctx:    ctx,
 		cancel: cancel,
 	}
+	q.SetConfig(cfg)
+	return q
+}
+
+// SetStreamHandler 流式输出音频数据，需要在 ListenResult 之前调用
+func (q *TTSQueue) SetStreamHandler(h StreamHandler) {
+	q.stream = h
+}
+
+// SetConfig 替换配置，用于配置热更新
+func (q *TTSQueue) SetConfig(cfg *config.TTSQueueConfig) {
+	q.cfg.Store(cfg)
+}
+
+func (q *TTSQueue) workers() int {
+	if n := q.cfg.Load().Workers; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (q *TTSQueue) Stats() QueueStats {
+	q.itemsMutex.Lock()
+	depth := len(q.items)
+	q.itemsMutex.Unlock()
+	return QueueStats{
+		Depth:    depth,
+		InFlight: int(q.inFlight.Load()),
+		Workers:  q.workers(),
+		Playing:  q.Playing(),
+	}
+}
+
+// Playing 正在播放的音频，没有时返回 nil
+func (q *TTSQueue) Playing() *Playback {
+	q.playingMutex.Lock()
+	defer q.playingMutex.Unlock()
+	if q.playing == nil {
+		return nil
+	}
+	p := *q.playing
+	return &p
+}
+
+// Ack 前端确认播放完成或者跳过，之后返回下一条结果，taskId 不是正在播放的音频时返回 false
+func (q *TTSQueue) Ack(taskId string) bool {
+	return q.finishPlayback(taskId) != nil
+}
+
+// Skip 跳过正在播放的音频，返回跳过的任务ID
+func (q *TTSQueue) Skip() (string, bool) {
+	p := q.finishPlayback("")
+	if p == nil {
+		return "", false
+	}
+	return p.TaskId, true
+}
+
+// finishPlayback taskId 为空时结束任意正在播放的音频
+func (q *TTSQueue) finishPlayback(taskId string) *Playback {
+	q.playingMutex.Lock()
+	defer q.playingMutex.Unlock()
+	p := q.playing
+	if p == nil || (taskId != "" && p.TaskId != taskId) {
+		return nil
+	}
+	close(p.done)
+	q.playing = nil
+	return p
 }
 
-type TaskWithChannel struct {
-	Task *Task
-	Done chan struct{}
+func (q *TTSQueue) startPlayback(r *TaskResult) *Playback {
+	p := &Playback{
+		TaskId:    r.TaskId,
+		Text:      r.Text,
+		Duration:  r.Duration.Milliseconds(),
+		StartTime: time.Now(),
+		done:      make(chan struct{}),
+	}
+	q.playingMutex.Lock()
+	q.playing = p
+	q.playingMutex.Unlock()
+	return p
+}
+
+// waitPlayback 等待前端确认，超过音频时长加上 timeout 后不再等待
+func (q *TTSQueue) waitPlayback(p *Playback, timeout time.Duration) bool {
+	timer := time.NewTimer(time.Duration(p.Duration)*time.Millisecond + timeout)
+	defer timer.Stop()
+	select {
+	case <-q.ctx.Done():
+		return false
+	case <-p.done:
+	case <-timer.C:
+		log.Warnf("tts %s playback not acknowledged, play next", p.TaskId)
+		q.finishPlayback(p.TaskId)
+	}
+	return true
 }
 
 func (q *TTSQueue) Push(params *NewTaskParams) error {
-	task, err := q.tts.NewTask(params)
-	if err != nil {
-		return fmt.Errorf("NewTask err: %w", err)
+	if q.ctx.Err() != nil {
+		return ErrQueueClosed
+	}
+
+	q.itemsMutex.Lock()
+	q.items = append(q.items, &queueItem{
+		params:   params,
+		pushTime: time.Now(),
+	})
+	if maxBacklog := q.cfg.Load().MaxBacklog; maxBacklog > 0 {
+		for len(q.items) > maxBacklog {
+			if !q.shed() {
+				break
+			}
+		}
 	}
+	q.itemsMutex.Unlock()
 
-	doneCh := make(chan struct{}, 1)
-	q.ch <- &TaskWithChannel{
-		Task: task,
-		Done: doneCh,
+	select {
+	case q.notify <- struct{}{}:
+	default:
 	}
-	go func() {
-		task.Run()
-		doneCh <- struct{}{}
-	}()
 	return nil
 }
 
+// shed 从最低优先级中丢弃最早的任务，礼物及以上的任务不会被丢弃
+func (q *TTSQueue) shed() bool {
+	idx := -1
+	for i, item := range q.items {
+		if item.params.Priority >= PriorityGift {
+			continue
+		}
+		if idx < 0 || item.params.Priority < q.items[idx].params.Priority {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+	q.drop(idx, DropReasonBacklog)
+	return true
+}
+
+func (q *TTSQueue) drop(idx int, reason string) {
+	item := q.items[idx]
+	q.items = append(q.items[:idx], q.items[idx+1:]...)
+	q.dropped = append(q.dropped, &TaskResult{
+		Text:       PlainText(item.params.Text),
+		Priority:   item.params.Priority,
+		DropReason: reason,
+	})
+}
+
+// next 丢弃过期任务并取出优先级最高的任务，同优先级先进先出
+func (q *TTSQueue) next() ([]*TaskResult, *queueItem) {
+	q.itemsMutex.Lock()
+	defer q.itemsMutex.Unlock()
+
+	if maxAge := q.cfg.Load().MaxAge.Duration(); maxAge > 0 {
+		now := time.Now()
+		for i := 0; i < len(q.items); {
+			item := q.items[i]
+			if item.params.Priority < PriorityGift && now.Sub(item.pushTime) > maxAge {
+				q.drop(i, DropReasonExpired)
+				continue
+			}
+			i++
+		}
+	}
+
+	dropped := q.dropped
+	q.dropped = nil
+
+	idx := -1
+	for i, item := range q.items {
+		if idx < 0 || item.params.Priority > q.items[idx].params.Priority {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return dropped, nil
+	}
+	item := q.items[idx]
+	q.items = append(q.items[:idx], q.items[idx+1:]...)
+	return dropped, item
+}
+
+func (q *TTSQueue) run(item *queueItem, relay *streamRelay) *TaskResult {
+	res := &TaskResult{
+		Text:     PlainText(item.params.Text),
+		Priority: item.params.Priority,
+	}
+	task, err := q.tts.NewTask(item.params)
+	if errors.Is(err, ErrEmptyText) {
+		res.DropReason = DropReasonEmpty
+		return res
+	}
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if relay != nil {
+		relay.start(task.TaskId, task.Request())
+		task.SetStream(relay)
+	}
+	task.Run(q.ctx)
+	res.TaskId = task.TaskId
+	res.Fname = task.Fname
+	res.Err = task.Err
+	res.Duration = task.Duration
+	res.Subtitles = task.Subtitles()
+	return res
+}
+
 type TaskResult struct {
-	TaskId string
-	Fname  string
-	Err    error
+	TaskId    string
+	Fname     string
+	Err       error
+	Duration  time.Duration
+	Subtitles []*Subtitle
+
+	Text       string // 显示的文本，不包括 SSML 标签
+	Priority   Priority
+	DropReason string // 不为空时任务被丢弃，没有合成
+}
+
+// pendingResult 已经取出的任务，结果按取出的顺序返回
+type pendingResult struct {
+	ch    chan *TaskResult
+	item  *queueItem   // 需要合成的任务，返回后释放 unreleased，丢弃的任务为 nil
+	relay *streamRelay // 设置了 StreamHandler 时推送合成中的音频
+}
+
+// expired 轮到任务播放时重新检查 max_age 和 max_backlog，等待合成和播放期间可能已经过期或者积压
+// 积压时只有等待中的任务都不比它优先级低才丢弃，和 shed 的顺序一致
+func (q *TTSQueue) expired(item *queueItem) string {
+	if item.params.Priority >= PriorityGift {
+		return ""
+	}
+	cfg := q.cfg.Load()
+	if maxAge := cfg.MaxAge.Duration(); maxAge > 0 && time.Since(item.pushTime) > maxAge {
+		return DropReasonExpired
+	}
+	if cfg.MaxBacklog <= 0 {
+		return ""
+	}
+	q.itemsMutex.Lock()
+	defer q.itemsMutex.Unlock()
+	if len(q.items)+int(q.unreleased.Load()) <= cfg.MaxBacklog {
+		return ""
+	}
+	for _, queued := range q.items {
+		if queued.params.Priority < item.params.Priority {
+			return ""
+		}
+	}
+	return DropReasonBacklog
 }
 
+// release 任务结果已经返回，可以取出下一个任务
+func (q *TTSQueue) release() {
+	q.unreleased.Add(-1)
+	select {
+	case q.released <- struct{}{}:
+	default:
+	}
+}
+
+// ListenResult 按取出任务的顺序返回结果，和合成完成的顺序无关，结果被读取后才会取出新的任务
+// ack_timeout 不为0时，返回音频后等待 Ack 或者 Skip 再返回下一条，返回前重新检查 max_age 和 max_backlog
 func (q *TTSQueue) ListenResult() <-chan *TaskResult {
-	ch := make(chan *TaskResult, 64)
+	ch := make(chan *TaskResult)
+	pending := make(chan *pendingResult, 64)
+	q.wg.Add(2)
+	go q.dispatch(pending)
 	go func() {
-		for {
+		defer q.wg.Done()
+		defer close(ch)
+		for f := range pending {
+			var reason string
+			if f.item != nil {
+				reason = q.expired(f.item)
+			}
+			if reason == "" && f.relay != nil {
+				f.relay.release()
+			}
+			var r *TaskResult
 			select {
 			case <-q.ctx.Done():
-				{
-					close(ch)
-					return
-				}
-			case r := <-q.ch:
-				{
-					<-r.Done
-					ch <- &TaskResult{
-						TaskId: r.Task.TaskId,
-						Fname:  r.Task.Fname,
-						Err:    r.Task.Err,
-					}
+				return
+			case r = <-f.ch:
+			}
+			if reason != "" && r.Err == nil && r.DropReason == "" {
+				r = &TaskResult{
+					Text:       r.Text,
+					Priority:   r.Priority,
+					DropReason: reason,
 				}
 			}
+			var p *Playback
+			timeout := q.cfg.Load().AckTimeout.Duration()
+			if timeout > 0 && r.Err == nil && r.DropReason == "" {
+				p = q.startPlayback(r)
+			}
+			if f.relay != nil {
+				f.relay.end(r)
+			}
+			select {
+			case <-q.ctx.Done():
+				return
+			case ch <- r:
+			}
+			if f.item != nil {
+				q.release()
+			}
+			if p != nil && !q.waitPlayback(p, timeout) {
+				return
+			}
 		}
 	}()
 	return ch
 }
 
+// dispatch 没有返回结果的任务少于 workers 个时取出任务合成，结果按顺序放入 pending
+func (q *TTSQueue) dispatch(pending chan<- *pendingResult) {
+	defer q.wg.Done()
+	defer close(pending)
+
+	wait := func(c <-chan struct{}, timeout time.Duration) bool {
+		var timer <-chan time.Time
+		if timeout > 0 {
+			timer = time.After(timeout)
+		}
+		select {
+		case <-q.ctx.Done():
+			return false
+		case <-c:
+		case <-timer:
+		}
+		return true
+	}
+	push := func(f *pendingResult) bool {
+		select {
+		case <-q.ctx.Done():
+			return false
+		case pending <- f:
+			return true
+		}
+	}
+
+	for {
+		if !q.tts.Available() {
+			q.tts.Probe(q.ctx)
+			if !q.tts.Available() {
+				if !wait(nil, pausedCheckInterval) {
+					return
+				}
+				continue
+			}
+		}
+		if int(q.unreleased.Load()) >= q.workers() {
+			if !wait(q.released, 0) {
+				return
+			}
+			continue
+		}
+
+		dropped, item := q.next()
+		for _, r := range dropped {
+			f := &pendingResult{ch: make(chan *TaskResult, 1)}
+			f.ch <- r
+			if !push(f) {
+				return
+			}
+		}
+		if item == nil {
+			if !wait(q.notify, 0) {
+				return
+			}
+			continue
+		}
+
+		f := &pendingResult{ch: make(chan *TaskResult, 1), item: item}
+		if q.stream != nil {
+			f.relay = &streamRelay{handler: q.stream}
+		}
+		q.unreleased.Add(1)
+		if !push(f) {
+			return
+		}
+		q.inFlight.Add(1)
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			f.ch <- q.run(item, f.relay)
+			q.inFlight.Add(-1)
+		}()
+	}
+}
+
+// Close 取消正在合成的任务，等待所有 goroutine 退出
 func (q *TTSQueue) Close() {
 	q.cancel()
+	q.wg.Wait()
 }
@@ -0,0 +1,371 @@
+package tts
+
+import (
+	"blive-vup-layer/config"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTTS 在临时目录中创建 TTS，合成结果写入临时目录
+func newTestTTS(t *testing.T, s Synthesizer) *TTS {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir err: %v", err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+	if err := os.WriteFile("config.toml", []byte("[aliyun_tts]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile err: %v", err)
+	}
+	cfg, err := config.ParseConfig("config.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig err: %v", err)
+	}
+	tts, err := NewTTS(cfg)
+	if err != nil {
+		t.Fatalf("NewTTS err: %v", err)
+	}
+	t.Cleanup(tts.Close)
+	tts.SetSynthesizer(s)
+	return tts
+}
+
+// slowSynthesizer 文本以 slow 开头时等待 100ms，文本为 block 时等待 ctx 取消，记录最大并发数
+type slowSynthesizer struct {
+	running    atomic.Int32
+	maxRunning atomic.Int32
+	canceled   atomic.Bool
+}
+
+func (s *slowSynthesizer) Name() string { return "slow" }
+
+func (s *slowSynthesizer) Synthesize(ctx context.Context, req *SynthesisRequest, w io.Writer) error {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	for {
+		max := s.maxRunning.Load()
+		if n <= max || s.maxRunning.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	switch {
+	case req.Text == "block":
+		<-ctx.Done()
+		s.canceled.Store(true)
+		return ctx.Err()
+	case strings.HasPrefix(req.Text, "slow"):
+		time.Sleep(100 * time.Millisecond)
+	}
+	_, err := w.Write([]byte(req.Text))
+	return err
+}
+
+func TestTTSQueuePriority(t *testing.T) {
+	q := NewTTSQueue(nil, &config.TTSQueueConfig{MaxBacklog: 3})
+	defer q.Close()
+
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "danmu1", Priority: PriorityDanmu}))
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "welcome", Priority: PriorityWelcome}))
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "gift", Priority: PriorityGift}))
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "danmu2", Priority: PriorityDanmu}))
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "sc", Priority: PrioritySuperChat}))
+
+	// 超过积压上限，先丢弃欢迎，再丢弃最早的弹幕
+	dropped, item := q.next()
+	if assert.Len(t, dropped, 2) {
+		assert.Equal(t, "welcome", dropped[0].Text)
+		assert.Equal(t, "danmu1", dropped[1].Text)
+		assert.Equal(t, DropReasonBacklog, dropped[0].DropReason)
+	}
+	assert.Equal(t, "sc", item.params.Text)
+
+	_, item = q.next()
+	assert.Equal(t, "gift", item.params.Text)
+	_, item = q.next()
+	assert.Equal(t, "danmu2", item.params.Text)
+	_, item = q.next()
+	assert.Nil(t, item)
+}
+
+func TestTTSQueueMaxAge(t *testing.T) {
+	q := NewTTSQueue(nil, &config.TTSQueueConfig{MaxAge: config.Duration(50 * time.Millisecond)})
+	defer q.Close()
+
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "danmu", Priority: PriorityDanmu}))
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "gift", Priority: PriorityGift}))
+	time.Sleep(100 * time.Millisecond)
+
+	dropped, item := q.next()
+	if assert.Len(t, dropped, 1) {
+		assert.Equal(t, "danmu", dropped[0].Text)
+		assert.Equal(t, DropReasonExpired, dropped[0].DropReason)
+	}
+	assert.Equal(t, "gift", item.params.Text)
+
+	q.Close()
+	assert.ErrorIs(t, q.Push(&NewTaskParams{Text: "danmu"}), ErrQueueClosed)
+}
+
+func TestTTSQueueWorkers(t *testing.T) {
+	s := &slowSynthesizer{}
+	q := NewTTSQueue(newTestTTS(t, s), &config.TTSQueueConfig{Workers: 2})
+	ch := q.ListenResult()
+
+	texts := []string{"slow a", "slow b", "c", "d"}
+	for _, text := range texts {
+		assert.NoError(t, q.Push(&NewTaskParams{Text: text, Priority: PriorityGift}))
+	}
+	for _, text := range texts {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Err)
+			assert.Equal(t, text, r.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait result timeout")
+		}
+	}
+	assert.EqualValues(t, 2, s.maxRunning.Load())
+	assert.Equal(t, QueueStats{Workers: 2}, q.Stats())
+
+	// Close 取消正在合成的任务
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "block", Priority: PriorityGift}))
+	assert.Eventually(t, func() bool {
+		return q.Stats().InFlight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	q.Close()
+	assert.True(t, s.canceled.Load())
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestTTSQueuePlayback(t *testing.T) {
+	q := NewTTSQueue(newTestTTS(t, &wavSynthesizer{}), &config.TTSQueueConfig{
+		Workers:    2,
+		AckTimeout: config.Duration(5 * time.Second),
+	})
+	defer q.Close()
+	ch := q.ListenResult()
+
+	recv := func() *TaskResult {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Err)
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait result timeout")
+		}
+		return nil
+	}
+	for _, text := range []string{"一二", "三四", "五"} {
+		assert.NoError(t, q.Push(&NewTaskParams{Text: text, Priority: PriorityGift}))
+	}
+
+	// 收到确认之前不返回下一条
+	r1 := recv()
+	assert.Equal(t, 200*time.Millisecond, r1.Duration)
+	select {
+	case <-ch:
+		t.Fatalf("result before ack")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if playing := q.Stats().Playing; assert.NotNil(t, playing) {
+		assert.Equal(t, r1.TaskId, playing.TaskId)
+		assert.EqualValues(t, 200, playing.Duration)
+	}
+	assert.False(t, q.Ack("unknown"))
+	assert.True(t, q.Ack(r1.TaskId))
+
+	// 服务端跳过
+	r2 := recv()
+	taskId, ok := q.Skip()
+	assert.True(t, ok)
+	assert.Equal(t, r2.TaskId, taskId)
+
+	// 没有确认时超过音频时长加上 ack_timeout 后返回下一条
+	q.SetConfig(&config.TTSQueueConfig{Workers: 2, AckTimeout: config.Duration(10 * time.Millisecond)})
+	recv()
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "六", Priority: PriorityGift}))
+	recv()
+	_, ok = q.Skip()
+	assert.True(t, ok)
+	assert.Nil(t, q.Playing())
+}
+
+func TestTTSQueueLookahead(t *testing.T) {
+	q := NewTTSQueue(newTestTTS(t, &slowSynthesizer{}), &config.TTSQueueConfig{Workers: 2})
+	defer q.Close()
+	ch := q.ListenResult()
+
+	for _, text := range []string{"d1", "d2", "d3", "d4", "d5", "d6"} {
+		assert.NoError(t, q.Push(&NewTaskParams{Text: text, Priority: PriorityDanmu}))
+	}
+	// 结果没有被读取时最多取出 workers 个任务
+	assert.Eventually(t, func() bool {
+		return q.Stats().Depth == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "sc", Priority: PrioritySuperChat}))
+
+	for _, text := range []string{"d1", "d2", "sc", "d3", "d4", "d5", "d6"} {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Err)
+			assert.Equal(t, text, r.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait result timeout")
+		}
+	}
+}
+
+func TestTTSQueueReleaseExpired(t *testing.T) {
+	q := NewTTSQueue(newTestTTS(t, &slowSynthesizer{}), &config.TTSQueueConfig{Workers: 2})
+	defer q.Close()
+	ch := q.ListenResult()
+
+	type want struct {
+		text   string
+		reason string
+	}
+	recv := func() *TaskResult {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Err)
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait result timeout")
+		}
+		return nil
+	}
+	check := func(wants []want) {
+		for _, w := range wants {
+			r := recv()
+			assert.Equal(t, w.text, r.Text)
+			assert.Equal(t, w.reason, r.DropReason)
+			if r.DropReason == "" {
+				q.Ack(r.TaskId)
+			}
+		}
+	}
+	// 礼物播放期间弹幕已经合成好，等待播放确认
+	playGift := func(cfg *config.TTSQueueConfig, texts ...string) *TaskResult {
+		q.SetConfig(cfg)
+		assert.NoError(t, q.Push(&NewTaskParams{Text: "gift", Priority: PriorityGift}))
+		gift := recv()
+		for i, text := range texts {
+			assert.NoError(t, q.Push(&NewTaskParams{Text: text, Priority: PriorityDanmu}))
+			if i != 1 {
+				continue
+			}
+			// 前两条取出合成之后再放入剩下的弹幕
+			assert.Eventually(t, func() bool {
+				stats := q.Stats()
+				return stats.Depth == 0 && stats.InFlight == 0
+			}, 5*time.Second, 10*time.Millisecond)
+		}
+		return gift
+	}
+	ackTimeout := config.Duration(5 * time.Second)
+
+	// 等待期间超过 max_age
+	gift := playGift(&config.TTSQueueConfig{
+		Workers:    2,
+		MaxAge:     config.Duration(50 * time.Millisecond),
+		AckTimeout: ackTimeout,
+	}, "d1", "d2", "d3", "d4")
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "sc", Priority: PrioritySuperChat}))
+	assert.True(t, q.Ack(gift.TaskId))
+	check([]want{
+		{"d1", DropReasonExpired},
+		{"d2", DropReasonExpired},
+		{"d3", DropReasonExpired},
+		{"d4", DropReasonExpired},
+		{"sc", ""},
+	})
+
+	// 已经合成好的弹幕加上等待中的任务超过 max_backlog
+	gift = playGift(&config.TTSQueueConfig{
+		Workers:    2,
+		MaxBacklog: 3,
+		AckTimeout: ackTimeout,
+	}, "d1", "d2", "d3", "d4", "d5")
+	assert.NoError(t, q.Push(&NewTaskParams{Text: "sc", Priority: PrioritySuperChat}))
+	assert.True(t, q.Ack(gift.TaskId))
+	check([]want{
+		{"d1", DropReasonBacklog},
+		{"d2", DropReasonBacklog},
+		{"d3", DropReasonBacklog},
+		{"sc", ""},
+		{"d4", ""},
+		{"d5", ""},
+	})
+}
+
+// recordStream 按顺序记录流式推送的事件
+type recordStream struct {
+	events []string
+	mutex  sync.Mutex
+}
+
+func (s *recordStream) add(event string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *recordStream) Events() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func (s *recordStream) OnStart(taskId string, req *SynthesisRequest) { s.add("start " + req.Text) }
+func (s *recordStream) OnChunk(taskId string, data []byte)           { s.add("chunk " + string(data)) }
+func (s *recordStream) OnEnd(r *TaskResult)                          { s.add("end " + r.Text) }
+
+func TestTTSQueueStream(t *testing.T) {
+	stream := &recordStream{}
+	q := NewTTSQueue(newTestTTS(t, &slowSynthesizer{}), &config.TTSQueueConfig{
+		Workers:    2,
+		AckTimeout: config.Duration(5 * time.Second),
+	})
+	defer q.Close()
+	q.SetStreamHandler(stream)
+	ch := q.ListenResult()
+
+	recv := func() *TaskResult {
+		select {
+		case r := <-ch:
+			assert.NoError(t, r.Err)
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait result timeout")
+		}
+		return nil
+	}
+	for _, text := range []string{"slow a", "b"} {
+		assert.NoError(t, q.Push(&NewTaskParams{Text: text, Priority: PriorityGift}))
+	}
+
+	// b 先合成完成，但是要等 a 播放确认之后才推送
+	r := recv()
+	assert.Equal(t, "slow a", r.Text)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"start slow a", "chunk slow a", "end slow a"}, stream.Events())
+
+	assert.True(t, q.Ack(r.TaskId))
+	r = recv()
+	assert.Equal(t, "b", r.Text)
+	assert.Equal(t, []string{
+		"start slow a", "chunk slow a", "end slow a",
+		"start b", "chunk b", "end b",
+	}, stream.Events())
+}
@@ -2,162 +2,318 @@ package tts
 
 import (
 	"blive-vup-layer/config"
-	nls "blive-vup-layer/tts/alibabacloud-nls-go-sdk"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 	"io"
-	syslog "log"
 	"os"
 	"path"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type TTS struct {
-	cfg *config.AliyunTTSConfig
+	synthesizer atomic.Pointer[Synthesizer]
+	normalizer  atomic.Pointer[Normalizer]
+	cfg         atomic.Pointer[config.Config]
+	cache       *Cache
+	janitor     *Janitor
+	tokens      *TokenManager
+
+	statusHandler atomic.Pointer[func(status *BreakerStatus)]
 }
 
-func NewTTS(cfg *config.AliyunTTSConfig) (*TTS, error) {
+func NewTTS(cfg *config.Config) (*TTS, error) {
 	if err := os.MkdirAll(config.ResultFilePath, os.ModePerm); err != nil {
 		return nil, err
 	}
-	return &TTS{cfg: cfg}, nil
+	cache, err := NewCache(config.TTSCachePath, cfg.TTSCache.MaxSizeBytes())
+	if err != nil {
+		return nil, fmt.Errorf("NewCache err: %w", err)
+	}
+	tts := &TTS{
+		cache:   cache,
+		janitor: NewJanitor(config.ResultFilePath, cfg.ResultFiles),
+		tokens:  NewTokenManager(cfg.AliyunTTS),
+	}
+	if err := tts.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	// 清理上次运行留下的过期文件
+	if err := tts.janitor.Clean(); err != nil {
+		return nil, fmt.Errorf("janitor Clean err: %w", err)
+	}
+	go tts.janitor.Run()
+	go tts.tokens.Run()
+	return tts, nil
+}
+
+// SetConfig 替换合成后端和缓存配置，用于配置热更新，只对之后创建的任务生效
+// 合成后端相关的配置没有变化时不重建合成后端，重建时沿用同名后端的熔断状态
+func (tts *TTS) SetConfig(cfg *config.Config) error {
+	if old := tts.cfg.Load(); old == nil || synthesizerConfigChanged(old, cfg) {
+		s, err := NewSynthesizer(cfg, tts.tokens, tts.onStatusChange)
+		if err != nil {
+			return err
+		}
+		if old := tts.synthesizer.Load(); old != nil {
+			inheritBreakers(s, *old)
+		}
+		tts.SetSynthesizer(s)
+	}
+	tts.tokens.SetConfig(cfg.AliyunTTS)
+	tts.normalizer.Store(NewNormalizer(cfg.TTSNormalize))
+	tts.cfg.Store(cfg)
+	tts.cache.SetMaxSize(cfg.TTSCache.MaxSizeBytes())
+	tts.janitor.SetConfig(cfg.ResultFiles)
+	return nil
+}
+
+// synthesizerConfigChanged 合成后端、重试熔断和阿里云配置是否变化，HTTP 后端的配置在 cfg.TTS 中
+func synthesizerConfigChanged(old, cfg *config.Config) bool {
+	return !reflect.DeepEqual(old.TTS, cfg.TTS) ||
+		!reflect.DeepEqual(old.TTSRetry, cfg.TTSRetry) ||
+		!reflect.DeepEqual(old.AliyunTTS, cfg.AliyunTTS)
+}
+
+// SetSynthesizer 替换合成后端
+func (tts *TTS) SetSynthesizer(s Synthesizer) {
+	tts.synthesizer.Store(&s)
+}
+
+func (tts *TTS) Synthesizer() Synthesizer { return *tts.synthesizer.Load() }
+
+func (tts *TTS) Cache() *Cache { return tts.cache }
+
+func (tts *TTS) Janitor() *Janitor { return tts.janitor }
+
+func (tts *TTS) Tokens() *TokenManager { return tts.tokens }
+
+// SetStatusHandler 合成后端熔断和恢复时调用 f
+func (tts *TTS) SetStatusHandler(f func(status *BreakerStatus)) {
+	tts.statusHandler.Store(&f)
+}
+
+func (tts *TTS) onStatusChange(status *BreakerStatus) {
+	if f := tts.statusHandler.Load(); f != nil {
+		(*f)(status)
+	}
+}
+
+// Statuses 每个合成后端的熔断状态
+func (tts *TTS) Statuses() []*BreakerStatus {
+	return synthesizerStatuses(tts.Synthesizer())
+}
+
+// Available 至少有一个合成后端没有熔断
+func (tts *TTS) Available() bool {
+	statuses := tts.Statuses()
+	for _, status := range statuses {
+		if !status.Open {
+			return true
+		}
+	}
+	return len(statuses) == 0
+}
+
+// Probe 探测熔断的合成后端是否恢复
+func (tts *TTS) Probe(ctx context.Context) {
+	probeSynthesizer(ctx, tts.Synthesizer())
+}
+
+// Health 返回影响合成的错误，为 nil 时正常
+func (tts *TTS) Health() error {
+	if err := tts.tokens.Err(); err != nil {
+		return fmt.Errorf("aliyun token: %w", err)
+	}
+	if !tts.Available() {
+		var errs []error
+		for _, status := range tts.Statuses() {
+			errs = append(errs, fmt.Errorf("%s circuit breaker open, %s: %s", status.Backend, status.Kind, status.Error))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (tts *TTS) Close() {
+	tts.janitor.Close()
+	tts.tokens.Close()
 }
 
 type Task struct {
 	TaskId string
 	Logger *log.Entry
 
-	File  *os.File
-	Fname string
-	Err   error
+	Fname    string
+	Err      error
+	Duration time.Duration // 音频时长，mp3 无法计算时为0
+
+	subtitles      []*Subtitle
+	subtitlesMutex sync.Mutex
+
+	req         *SynthesisRequest
+	synthesizer Synthesizer
 
-	param           nls.SpeechSynthesisStartParam
-	text            string
-	speechSynthesis *nls.SpeechSynthesis
+	cache    *Cache
+	cacheKey string
+	cached   bool // 命中缓存，不需要合成
+	fallback bool // 由备用后端合成，结果不写入缓存，主后端恢复后重新合成
+
+	stream io.Writer
 }
 
 type NewTaskParams struct {
-	Text      string
-	PitchRate int
+	Text     string // 以 <speak> 开头时为 SSML
+	Uname    string // 文本中的用户名，整理文本时缩短
+	Event    string // 选择音色的事件，config.VoiceEventXXX
+	Priority Priority
 }
 
+// ErrEmptyText 整理后没有需要合成的文本
+var ErrEmptyText = errors.New("tts text is empty")
+
 func (tts *TTS) NewTask(params *NewTaskParams) (*Task, error) {
+	text := tts.normalizer.Load().Normalize(params.Text, params.Uname)
+	if text == "" {
+		return nil, ErrEmptyText
+	}
+
 	taskId := uuid.NewV4().String()
 	l := log.WithField("task_id", taskId)
 
-	fname := path.Join(config.ResultFilePath, fmt.Sprintf("tts-%s.wav", taskId))
-	fout, err := os.OpenFile(fname, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0666)
-	if err != nil {
-		return nil, err
+	voice := tts.cfg.Load().Voice(params.Event)
+	req := &SynthesisRequest{
+		Text:       text,
+		Voice:      voice.Voice,
+		Format:     voice.Format,
+		SampleRate: voice.SampleRate,
+		Volume:     voice.Volume,
+		SpeechRate: voice.SpeechRate,
+		PitchRate:  voice.PitchRate,
 	}
-	param := nls.SpeechSynthesisStartParam{
-		Voice:      "voice-3e06127",
-		Format:     "wav",
-		SampleRate: 48000,
-		Volume:     50,
-		SpeechRate: -100,
-		PitchRate:  params.PitchRate,
-	}
-
 	t := &Task{
 		TaskId: taskId,
 		Logger: l,
-		File:   fout,
-		Fname:  fname,
-
-		param: param,
-		text:  params.Text,
-	}
-
-	l.Infof("new tts: %s", t.text)
-	nlsLog := nls.NewNlsLogger(io.Discard, "NLS", syslog.LstdFlags|syslog.Lmicroseconds)
-	//nlsLog.SetDebug(true)
+		Fname:  path.Join(config.ResultFilePath, fmt.Sprintf("tts-%s.%s", taskId, req.Format)),
 
-	nlsCfg, err := nls.NewConnectionConfigWithAKInfoDefault(
-		nls.DEFAULT_URL,
-		tts.cfg.AppKey, tts.cfg.AccessKey, tts.cfg.SecretKey,
-	)
-	if err != nil {
-		log.Errorf("NewConnectionConfigWithAKInfoDefault err: %v", err)
-		return nil, err
-	}
+		req:         req,
+		synthesizer: tts.Synthesizer(),
 
-	ss, err := nls.NewSpeechSynthesis(nlsCfg, nlsLog, false,
-		t.onTaskFailed, t.onSynthesisResult, nil,
-		t.onCompleted, t.onClose, param)
-	if err != nil {
-		log.Errorf("NewTask err: %v", err)
-		return nil, err
+		cache:    tts.cache,
+		cacheKey: req.CacheKey(),
 	}
-
-	t.speechSynthesis = ss
-	return t, nil
-}
-
-func (task *Task) Run() (string, error) {
-	defer task.File.Close()
-	ch, err := task.speechSynthesis.Start(task.text, task.param, nil)
-	if err != nil {
-		task.Logger.Errorf("Start err: %v", err)
-		task.speechSynthesis.Shutdown()
-		task.Err = err
-		return "", err
+	req.OnSubtitles = t.addSubtitles
+	req.OnFallback = func(backend string) {
+		l.Warnf("synthesized by fallback backend %s, skip cache", backend)
+		t.fallback = true
 	}
-
-	err = task.waitReady(ch)
-	if err != nil {
-		task.Err = err
-		return "", err
+	if fname, ok := tts.cache.Get(t.cacheKey); ok {
+		l.Infof("tts cache hit: %s", text)
+		t.Fname = fname
+		t.cached = true
+		if subtitleFname, ok := tts.cache.Get(subtitleCacheKey(t.cacheKey)); ok {
+			subtitles, err := readSubtitles(subtitleFname)
+			if err != nil {
+				l.Errorf("read subtitles err: %v", err)
+			}
+			t.subtitles = subtitles
+		}
+		return t, nil
 	}
-	task.Logger.Infof("Synthesis done")
-	task.speechSynthesis.Shutdown()
-
-	go func() {
-		cleanTimer := time.NewTimer(time.Hour)
-		defer cleanTimer.Stop()
-		<-cleanTimer.C
-		os.Remove(task.Fname)
-	}()
 
-	return task.Fname, nil
+	l.Infof("new tts: %s", text)
+	return t, nil
 }
 
-func (task *Task) onTaskFailed(text string, param interface{}) {
-	task.Logger.Errorf("TaskFailed: %s", text)
-	task.File.Close()
+func (task *Task) addSubtitles(subtitles []*Subtitle) {
+	task.subtitlesMutex.Lock()
+	task.subtitles = append(task.subtitles, subtitles...)
+	task.subtitlesMutex.Unlock()
 }
 
-func (task *Task) onSynthesisResult(data []byte, param interface{}) {
-	task.File.Write(data)
+// Subtitles 字幕时间轴，合成后端不支持时为空
+func (task *Task) Subtitles() []*Subtitle {
+	task.subtitlesMutex.Lock()
+	defer task.subtitlesMutex.Unlock()
+	return task.subtitles
 }
 
-func (task *Task) onCompleted(text string, param interface{}) {
-	task.Logger.Infof("onCompleted: %s", text)
-	task.File.Close()
-}
+// Request 合成参数
+func (task *Task) Request() *SynthesisRequest { return task.req }
 
-func (task *Task) onClose(param interface{}) {
-	task.Logger.Infof("onClosed")
-	task.File.Close()
+// SetStream 合成时同时把音频数据写入 w，命中缓存时一次写入整个文件，需要在 Run 之前调用
+func (task *Task) SetStream(w io.Writer) {
+	task.stream = w
 }
 
-func (task *Task) waitReady(ch chan bool) error {
-	select {
-	case done := <-ch:
-		{
-			if !done {
-				task.Logger.Error("wait failed")
-				return errors.New("wait failed")
+// Run 合成语音，ctx 取消时停止合成
+func (task *Task) Run(ctx context.Context) (string, error) {
+	if task.cached {
+		data, err := os.ReadFile(task.Fname)
+		if err != nil {
+			task.Logger.Errorf("ReadFile err: %v", err)
+			task.Err = err
+			return "", err
+		}
+		task.Duration, _ = audioDuration(task.req.Format, task.req.SampleRate, data)
+		if task.stream != nil {
+			if _, err := task.stream.Write(data); err != nil {
+				task.Logger.Errorf("copy cache to stream err: %v", err)
+				task.Err = err
+				return "", err
 			}
-			task.Logger.Debugf("Wait done")
 		}
-	case <-time.After(60 * time.Second):
-		{
-			task.Logger.Error("Wait timeout")
-			return errors.New("wait timeout")
+		return task.Fname, nil
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if task.stream != nil {
+		w = io.MultiWriter(&buf, task.stream)
+	}
+	if err := task.synthesizer.Synthesize(ctx, task.req, w); err != nil {
+		task.Logger.Errorf("Synthesize err: %v", err)
+		task.Err = err
+		return "", err
+	}
+	if err := os.WriteFile(task.Fname, buf.Bytes(), 0666); err != nil {
+		task.Logger.Errorf("WriteFile err: %v", err)
+		task.Err = err
+		return "", err
+	}
+	task.Duration, _ = audioDuration(task.req.Format, task.req.SampleRate, buf.Bytes())
+	task.Logger.Infof("Synthesis done, duration: %s", task.Duration)
+
+	if task.cache.Enabled() && !task.fallback {
+		fname, err := task.cache.Put(task.cacheKey, task.Fname)
+		if err != nil {
+			task.Logger.Errorf("put tts cache err: %v", err)
+		} else {
+			task.Fname = fname
 		}
+		task.putSubtitlesCache()
+	}
+	return task.Fname, nil
+}
+
+func (task *Task) putSubtitlesCache() {
+	subtitles := task.Subtitles()
+	if len(subtitles) == 0 {
+		return
+	}
+	fname := path.Join(config.ResultFilePath, fmt.Sprintf("tts-%s.json", task.TaskId))
+	if err := writeSubtitles(fname, subtitles); err != nil {
+		task.Logger.Errorf("write subtitles err: %v", err)
+		return
+	}
+	if _, err := task.cache.Put(subtitleCacheKey(task.cacheKey), fname); err != nil {
+		task.Logger.Errorf("put subtitles cache err: %v", err)
 	}
-	return nil
 }
@@ -0,0 +1,118 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// wavFormat WAV 文件 fmt 块
+type wavFormat struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+func (f *wavFormat) duration(pcmLen int) time.Duration {
+	if f.ByteRate == 0 {
+		return 0
+	}
+	return time.Duration(int64(pcmLen) * int64(time.Second) / int64(f.ByteRate))
+}
+
+// parseWAV 返回格式和 PCM 数据，流式合成时 data 块的长度可能不准确，以实际数据为准
+func parseWAV(data []byte) (*wavFormat, []byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, nil, errors.New("not a wav file")
+	}
+	var format *wavFormat
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		switch id {
+		case "fmt ":
+			if size < 16 || len(body) < 16 {
+				return nil, nil, errors.New("invalid wav fmt chunk")
+			}
+			format = &wavFormat{}
+			binary.Read(bytes.NewReader(body[:16]), binary.LittleEndian, format)
+		case "data":
+			if format == nil {
+				return nil, nil, errors.New("wav data chunk before fmt chunk")
+			}
+			if size == 0 || size > len(body) {
+				size = len(body)
+			}
+			return format, body[:size], nil
+		}
+		off += 8 + size + size%2
+	}
+	return nil, nil, errors.New("wav data chunk not found")
+}
+
+// encodeWAV 生成只有 fmt 和 data 块的 WAV 文件
+func encodeWAV(f *wavFormat, pcm []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(44 + len(pcm))
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, f)
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// audioDuration 计算音频时长，只支持 wav 和 16 位单声道的 pcm
+func audioDuration(format string, sampleRate int, data []byte) (time.Duration, bool) {
+	switch format {
+	case "wav":
+		f, pcm, err := parseWAV(data)
+		if err != nil {
+			return 0, false
+		}
+		return f.duration(len(pcm)), true
+	case "pcm":
+		if sampleRate <= 0 {
+			return 0, false
+		}
+		return time.Duration(int64(len(data)) * int64(time.Second) / int64(sampleRate*2)), true
+	}
+	return 0, false
+}
+
+// concatAudio 拼接多段音频，wav 合并 PCM 数据后重新生成文件头，pcm 和 mp3 直接拼接
+func concatAudio(format string, segments [][]byte) ([]byte, error) {
+	if format != "wav" {
+		return bytes.Join(segments, nil), nil
+	}
+	var (
+		wf  *wavFormat
+		pcm []byte
+	)
+	for i, data := range segments {
+		f, p, err := parseWAV(data)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		if wf == nil {
+			wf = f
+		} else if *f != *wf {
+			return nil, fmt.Errorf("segment %d: wav format mismatch", i)
+		}
+		pcm = append(pcm, p...)
+	}
+	if wf == nil {
+		return nil, errors.New("no audio segment")
+	}
+	return encodeWAV(wf, pcm), nil
+}
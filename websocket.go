@@ -7,12 +7,16 @@ import (
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 type WebSocketConn struct {
 	conn *websocket.Conn
 
 	connMutex sync.Mutex
+
+	streamAudio atomic.Bool
+	playAudio   atomic.Bool
 }
 
 func NewWebSocketConn(c *gin.Context) (*WebSocketConn, error) {
@@ -82,4 +86,21 @@ func (c *WebSocketConn) WriteResult(res *WebSocketResult) error {
 	return c.conn.WriteMessage(websocket.TextMessage, msg)
 }
 
+// WriteBinary 写入二进制帧，用于推送音频数据
+func (c *WebSocketConn) WriteBinary(data []byte) error {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// SetStreamAudio 开启后通过二进制帧接收TTS音频，不再接收 ResultTypeTTS
+func (c *WebSocketConn) SetStreamAudio(enabled bool) { c.streamAudio.Store(enabled) }
+
+func (c *WebSocketConn) StreamAudio() bool { return c.streamAudio.Load() }
+
+// SetPlayAudio 请求播放TTS音频，需要在加入会话之前设置
+func (c *WebSocketConn) SetPlayAudio(enabled bool) { c.playAudio.Store(enabled) }
+
+func (c *WebSocketConn) PlayAudio() bool { return c.playAudio.Load() }
+
 func (c *WebSocketConn) Close() error { return c.conn.Close() }